@@ -0,0 +1,25 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package gnet
+
+import "github.com/panjf2000/gnet/v2/internal/netpoll"
+
+// rewatchListener starts watching the readable events of the listener again after it was stopped by ModNone.
+func (el *eventloop) rewatchListener(pa *netpoll.PollAttachment) error {
+	return el.poller.ModRead(pa)
+}
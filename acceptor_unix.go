@@ -18,6 +18,8 @@
 package gnet
 
 import (
+	"fmt"
+	"net"
 	"os"
 	"time"
 
@@ -30,62 +32,312 @@ import (
 )
 
 func (eng *engine) accept(fd int, _ netpoll.IOEvent) error {
-	nfd, sa, err := unix.Accept(fd)
-	if err != nil {
-		if err == unix.EAGAIN {
+	ln, ok := eng.mainLoop.listeners[fd]
+	if !ok {
+		return nil
+	}
+
+	for i := acceptBatchSize(eng.opts); i > 0; i-- {
+		if eng.mainLoop.throttleAccept() {
 			return nil
 		}
-		eng.opts.Logger.Errorf("Accept() fails due to error: %v", err)
-		return errors.ErrAcceptSocket
+		nfd, sa, err := socket.Accept(fd)
+		if err != nil {
+			if err == unix.EAGAIN {
+				return nil
+			}
+			eng.opts.Logger.Errorf("Accept() fails due to error: %v", err)
+			return errors.ErrAcceptSocket
+		}
+		if eng.acceptLimit != nil {
+			eng.acceptLimit.take()
+		}
+		eng.dispatch(ln, nfd, sa)
 	}
-	if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(nfd, true)); err != nil {
-		return err
+	return nil
+}
+
+// dispatch sets up the connection accepted by the main event-loop and registers it on
+// an event-loop chosen by the load balancer.
+func (eng *engine) dispatch(ln *listener, nfd int, sa unix.Sockaddr) {
+	remoteAddr := ln.remoteAddr(sa)
+	if !eng.filter(ln, nfd, remoteAddr) {
+		return
+	}
+
+	cred, err := ln.authorizePeer(eng.opts, nfd)
+	if err != nil {
+		eng.opts.Logger.Warnf("Rejected the connection on fd=%d due to error: %v", nfd, err)
+		_ = unix.Close(nfd)
+		return
 	}
 
-	remoteAddr := socket.SockaddrToTCPOrUnixAddr(sa)
-	if eng.opts.TCPKeepAlive > 0 && eng.ln.network == "tcp" {
-		err = socket.SetKeepAlive(nfd, int(eng.opts.TCPKeepAlive/time.Second))
+	if ln.network == "tcp" {
+		err = setSockOpts(nfd, eng.tcpSockOpts)
 		logging.Error(err)
 	}
+	if err = controlSocket(nfd, eng.opts.SocketOptions); err != nil {
+		eng.opts.Logger.Warnf("Rejected the connection on fd=%d due to error: %v", nfd, err)
+		_ = unix.Close(nfd)
+		return
+	}
+
+	if !eng.admit(nfd, remoteAddr) {
+		return
+	}
 
 	el := eng.lb.next(remoteAddr)
-	c := newTCPConn(nfd, el, sa, el.ln.addr, remoteAddr)
+	c := newTCPConn(nfd, el, sa, ln.addr, remoteAddr)
+	c.ln = ln
+	c.peerCred = cred
+	c.admitted = eng.admission != nil
 
 	err = el.poller.UrgentTrigger(el.register, c)
 	if err != nil {
 		_ = unix.Close(nfd)
 		c.releaseTCP()
 	}
-	return nil
 }
 
 func (el *eventloop) accept(fd int, ev netpoll.IOEvent) error {
-	if el.ln.network == "udp" {
+	// Client-side UDP sockets are also dispatched here by the pollers without attachments.
+	ln, ok := el.listeners[fd]
+	if !ok || ln.isDatagram() {
 		return el.readUDP(fd, ev)
 	}
 
-	nfd, sa, err := unix.Accept(el.ln.fd)
-	if err != nil {
-		if err == unix.EAGAIN {
+	for i := acceptBatchSize(el.engine.opts); i > 0; i-- {
+		if el.throttleAccept() {
 			return nil
 		}
-		el.getLogger().Errorf("Accept() fails due to error: %v", err)
-		return os.NewSyscallError("accept", err)
+		nfd, sa, err := socket.Accept(fd)
+		if err != nil {
+			if err == unix.EAGAIN {
+				return nil
+			}
+			el.getLogger().Errorf("Accept() fails due to error: %v", err)
+			return os.NewSyscallError("accept", err)
+		}
+		if el.engine.acceptLimit != nil {
+			el.engine.acceptLimit.take()
+		}
+		if err = el.openAccepted(ln, nfd, sa); err != nil {
+			return err
+		}
 	}
-	if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(nfd, true)); err != nil {
-		return err
+	return nil
+}
+
+// openAccepted sets up the connection accepted by the event-loop and opens it.
+func (el *eventloop) openAccepted(ln *listener, nfd int, sa unix.Sockaddr) error {
+	remoteAddr := ln.remoteAddr(sa)
+	if !el.engine.filter(ln, nfd, remoteAddr) {
+		return nil
+	}
+
+	cred, err := ln.authorizePeer(el.engine.opts, nfd)
+	if err != nil {
+		el.getLogger().Warnf("Rejected the connection on fd=%d due to error: %v", nfd, err)
+		_ = unix.Close(nfd)
+		return nil
 	}
 
-	remoteAddr := socket.SockaddrToTCPOrUnixAddr(sa)
-	if el.engine.opts.TCPKeepAlive > 0 && el.ln.network == "tcp" {
-		err = socket.SetKeepAlive(nfd, int(el.engine.opts.TCPKeepAlive/time.Second))
+	if ln.network == "tcp" {
+		err = setSockOpts(nfd, el.engine.tcpSockOpts)
 		logging.Error(err)
 	}
+	if err = controlSocket(nfd, el.engine.opts.SocketOptions); err != nil {
+		el.getLogger().Warnf("Rejected the connection on fd=%d due to error: %v", nfd, err)
+		_ = unix.Close(nfd)
+		return nil
+	}
+
+	if !el.engine.admit(nfd, remoteAddr) {
+		return nil
+	}
 
-	c := newTCPConn(nfd, el, sa, el.ln.addr, remoteAddr)
+	c := newTCPConn(nfd, el, sa, ln.addr, remoteAddr)
+	c.ln = ln
+	c.peerCred = cred
+	c.admitted = el.engine.admission != nil
 	if err = el.poller.AddRead(c.pollAttachment); err != nil {
+		_ = unix.Close(nfd)
+		c.releaseTCP()
 		return err
 	}
 	el.connections[c.fd] = c
 	return el.open(c)
 }
+
+// filter checks the connection nfd from remoteAddr with OnAccept if the EventHandler implements AcceptFilter,
+// the connection is closed right away if it's rejected.
+func (eng *engine) filter(ln *listener, nfd int, remoteAddr net.Addr) bool {
+	if f, ok := eng.eventHandler.(AcceptFilter); ok && f.OnAccept(remoteAddr, ln.addr) != None {
+		_ = unix.Close(nfd)
+		return false
+	}
+	return true
+}
+
+// admit checks the connection nfd from remoteAddr against the connection limits, the connection is closed
+// right away after RejectPayload is written to it if it exceeds any of the limits.
+func (eng *engine) admit(nfd int, remoteAddr net.Addr) bool {
+	if eng.admission == nil || eng.admission.admit(remoteAddr) {
+		return true
+	}
+	if len(eng.opts.RejectPayload) > 0 {
+		_, _ = unix.Write(nfd, eng.opts.RejectPayload)
+	}
+	_ = unix.Close(nfd)
+	return false
+}
+
+// addListener registers the listener ln on the poller of the event-loop with handler.
+func (el *eventloop) addListener(ln *listener, handler netpoll.PollEventHandler) error {
+	if el.lnAttachments == nil {
+		el.lnAttachments = make(map[int]*netpoll.PollAttachment)
+	}
+	pa := ln.packPollAttachment(handler)
+	el.listeners[ln.fd] = ln
+	el.lnAttachments[ln.fd] = pa
+	return el.poller.AddRead(pa)
+}
+
+// watchListeners starts/stops watching the readable events of the stream listeners of the event-loop,
+// the pending connections stay in the backlogs of the listeners while they're not watched. All listeners
+// are handled even if some of them fail, and the errors are combined.
+func (el *eventloop) watchListeners(watch bool) (err error) {
+	for fd, ln := range el.listeners {
+		if ln.isDatagram() {
+			continue
+		}
+		pa := el.lnAttachments[fd]
+		if watch {
+			err = appendError(err, el.rewatchListener(pa))
+		} else {
+			err = appendError(err, el.poller.ModNone(pa))
+		}
+	}
+	return
+}
+
+// appendError combines err with e in the form of "err & e", either of them can be nil.
+func appendError(err, e error) error {
+	if err == nil {
+		return e
+	}
+	if e == nil {
+		return err
+	}
+	return fmt.Errorf("%v & %v", err, e)
+}
+
+// pauseAccept pauses/resumes accepting connections on the event-loop, see Engine.PauseAccept.
+// The listeners are restored to the previous state if any of them fails to be paused/resumed.
+func (el *eventloop) pauseAccept(pause bool) error {
+	if el.acceptPaused == pause || el.engine.isDraining() {
+		return nil
+	}
+	el.acceptPaused = pause
+	if el.acceptThrottled {
+		return nil
+	}
+	err := el.watchListeners(!pause)
+	if err != nil {
+		el.acceptPaused = !pause
+		if e := el.watchListeners(pause); e != nil {
+			el.getLogger().Errorf("failed to restore the listeners in event-loop(%d): %v", el.idx, e)
+		}
+	}
+	return err
+}
+
+// throttleAccept reports whether accepting connections is throttled by the rate limiter, in which case
+// the stream listeners of the event-loop are not watched until a token is available.
+func (el *eventloop) throttleAccept() bool {
+	if el.engine.acceptLimit == nil {
+		return false
+	}
+	wait := el.engine.acceptLimit.wait()
+	if wait <= 0 {
+		return false
+	}
+	if !el.acceptThrottled {
+		el.acceptThrottled = true
+		if !el.acceptPaused {
+			if err := el.watchListeners(false); err != nil {
+				el.getLogger().Errorf("failed to throttle accepting in event-loop(%d): %v", el.idx, err)
+			}
+		}
+		time.AfterFunc(wait, func() {
+			_ = el.poller.UrgentTrigger(el.unthrottleAccept, nil)
+		})
+	}
+	return true
+}
+
+// unthrottleAccept resumes accepting connections throttled by throttleAccept.
+func (el *eventloop) unthrottleAccept(_ interface{}) error {
+	el.acceptThrottled = false
+	if el.acceptPaused || el.engine.isDraining() {
+		return nil
+	}
+	if err := el.watchListeners(true); err != nil {
+		el.getLogger().Errorf("failed to resume accepting in event-loop(%d): %v", el.idx, err)
+	}
+	return nil
+}
+
+// acceptBatchSize returns the maximum number of connections accepted in a row, see Options.AcceptBatchSize.
+func acceptBatchSize(opts *Options) int {
+	if opts.AcceptBatchSize > 0 {
+		return opts.AcceptBatchSize
+	}
+	return 1
+}
+
+// Enroll adopts c, which is created by the standard library or other libraries, into the engine.
+// The connection is registered on an event-loop chosen by the load balancer and goes through the same
+// lifecycle (OnOpen, OnTraffic and OnClose) as the connections accepted by the engine, except that
+// OnOpen is not fired for UDP sockets, just like the ones dialed by Client.
+//
+// The socket underlying c is duplicated and c itself is closed before Enroll returns. The returned Conn
+// is owned by the event-loop, only its concurrency-safe methods (AsyncWrite, AsyncWritev, Wake and Close)
+// may be called outside the event-loop, the others must be called in the event handlers, like OnOpen.
+func (s Engine) Enroll(c net.Conn) (Conn, error) {
+	defer c.Close()
+	eng := s.eng
+	if eng.isInShutdown() || eng.isDraining() {
+		return nil, errors.ErrEngineInShutdown
+	}
+
+	nfd, err := dupSyscallConn(c)
+	if err != nil {
+		return nil, err
+	}
+
+	el := eng.lb.next(c.RemoteAddr())
+	gc, err := newConnFromNetConn(nfd, el, c)
+	if err != nil {
+		_ = unix.Close(nfd)
+		return nil, err
+	}
+	if _, ok := c.(*net.TCPConn); ok {
+		err = setSockOpts(nfd, eng.tcpSockOpts)
+		logging.Error(err)
+	}
+
+	if err = controlSocket(nfd, eng.opts.SocketOptions); err == nil {
+		err = el.poller.UrgentTrigger(el.register, gc)
+	}
+	if err != nil {
+		_ = unix.Close(nfd)
+		if gc.isDatagram {
+			gc.releaseUDP()
+		} else {
+			gc.releaseTCP()
+		}
+		return nil, err
+	}
+	return gc, nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || darwin
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/panjf2000/gnet/v2/pkg/errors"
+)
+
+// listenFdsStart is the first file descriptor passed by the service manager.
+const listenFdsStart = 3
+
+var activated struct {
+	sync.Mutex
+	fds     int              // number of file descriptors passed by the service manager
+	names   []string         // names of the file descriptors assigned by LISTEN_FDNAMES
+	claimed map[int]struct{} // file descriptors that have been claimed by listeners
+}
+
+// loadActivatedFds parses the environment variables set by the service manager, which are cleared
+// afterwards like sd_listen_fds(unset_environment=1) does to prevent them from being leaked to other
+// processes spawned by this process, including the one started by Engine.Restart.
+func loadActivatedFds() {
+	pidEnv, ok := os.LookupEnv("LISTEN_PID")
+	if !ok {
+		return
+	}
+	fdsEnv, namesEnv := os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	activated.fds, activated.names, activated.claimed = 0, nil, make(map[int]struct{})
+	if pid, err := strconv.Atoi(pidEnv); err != nil || pid != os.Getpid() {
+		return
+	}
+	n, err := strconv.Atoi(fdsEnv)
+	if err != nil || n <= 0 {
+		return
+	}
+	activated.fds = n
+	if namesEnv != "" {
+		activated.names = strings.Split(namesEnv, ":")
+	}
+}
+
+// activatedFd looks up the file descriptor referred to by name, which is either the number of
+// the file descriptor or the name assigned by LISTEN_FDNAMES, each file descriptor can only be
+// claimed once.
+func activatedFd(name string) (int, error) {
+	activated.Lock()
+	defer activated.Unlock()
+	loadActivatedFds()
+
+	if fd, err := strconv.Atoi(name); err == nil {
+		if fd < listenFdsStart || fd >= listenFdsStart+activated.fds {
+			return -1, errors.ErrActivatedListenerNotFound
+		}
+		if _, ok := activated.claimed[fd]; ok {
+			return -1, errors.ErrActivatedListenerNotFound
+		}
+		activated.claimed[fd] = struct{}{}
+		return fd, nil
+	}
+
+	for i := 0; i < activated.fds && i < len(activated.names); i++ {
+		fd := listenFdsStart + i
+		if activated.names[i] != name {
+			continue
+		}
+		if _, ok := activated.claimed[fd]; ok {
+			continue
+		}
+		activated.claimed[fd] = struct{}{}
+		return fd, nil
+	}
+	return -1, errors.ErrActivatedListenerNotFound
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultCIDRPrefixIPv4 is the default prefix length of the IPv4 networks limited by MaxConnsPerCIDR.
+	DefaultCIDRPrefixIPv4 = 24
+
+	// DefaultCIDRPrefixIPv6 is the default prefix length of the IPv6 networks limited by MaxConnsPerCIDR.
+	DefaultCIDRPrefixIPv6 = 64
+)
+
+// admission enforces the limits of the concurrent connections accepted by the engine,
+// see Options.MaxConns, Options.MaxConnsPerIP and Options.MaxConnsPerCIDR.
+type admission struct {
+	mu              sync.Mutex
+	maxConns        int
+	maxConnsPerIP   int
+	maxConnsPerCIDR int
+	maskIPv4        net.IPMask
+	maskIPv6        net.IPMask
+	conns           int            // number of the admitted connections
+	perIP           map[string]int // number of the admitted connections by source IP
+	perCIDR         map[string]int // number of the admitted connections by source network
+	rejected        int64          // number of the rejected connections
+}
+
+// newAdmission returns the admission with the connection limits of opts, nil if there are no limits.
+func newAdmission(opts *Options) *admission {
+	if opts.MaxConns <= 0 && opts.MaxConnsPerIP <= 0 && opts.MaxConnsPerCIDR <= 0 {
+		return nil
+	}
+	a := &admission{maxConns: opts.MaxConns, maxConnsPerIP: opts.MaxConnsPerIP, maxConnsPerCIDR: opts.MaxConnsPerCIDR}
+	if a.maxConnsPerIP > 0 {
+		a.perIP = make(map[string]int)
+	}
+	if a.maxConnsPerCIDR > 0 {
+		a.perCIDR = make(map[string]int)
+		prefixIPv4, prefixIPv6 := opts.CIDRPrefixIPv4, opts.CIDRPrefixIPv6
+		if prefixIPv4 <= 0 || prefixIPv4 > 8*net.IPv4len {
+			prefixIPv4 = DefaultCIDRPrefixIPv4
+		}
+		if prefixIPv6 <= 0 || prefixIPv6 > 8*net.IPv6len {
+			prefixIPv6 = DefaultCIDRPrefixIPv6
+		}
+		a.maskIPv4 = net.CIDRMask(prefixIPv4, 8*net.IPv4len)
+		a.maskIPv6 = net.CIDRMask(prefixIPv6, 8*net.IPv6len)
+	}
+	return a
+}
+
+// keys returns the keys of the source IP and the source network of addr,
+// which are empty if addr is not an IP address.
+func (a *admission) keys(addr net.Addr) (ipKey, cidrKey string) {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	ip := tcpAddr.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if a.perCIDR != nil {
+			cidrKey = string(ip.Mask(a.maskIPv4))
+		}
+	} else if a.perCIDR != nil {
+		cidrKey = string(ip.Mask(a.maskIPv6))
+	}
+	if a.perIP != nil {
+		ipKey = string(ip)
+	}
+	return
+}
+
+// admit reports whether a new connection from addr is within the limits, the connection is counted in if so.
+func (a *admission) admit(addr net.Addr) bool {
+	ipKey, cidrKey := a.keys(addr)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if (a.maxConns > 0 && a.conns >= a.maxConns) ||
+		(ipKey != "" && a.perIP[ipKey] >= a.maxConnsPerIP) ||
+		(cidrKey != "" && a.perCIDR[cidrKey] >= a.maxConnsPerCIDR) {
+		atomic.AddInt64(&a.rejected, 1)
+		return false
+	}
+	a.conns++
+	if ipKey != "" {
+		a.perIP[ipKey]++
+	}
+	if cidrKey != "" {
+		a.perCIDR[cidrKey]++
+	}
+	return true
+}
+
+// release counts out the connection from addr admitted by admit.
+func (a *admission) release(addr net.Addr) {
+	ipKey, cidrKey := a.keys(addr)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.conns--
+	if ipKey != "" {
+		if a.perIP[ipKey]--; a.perIP[ipKey] <= 0 {
+			delete(a.perIP, ipKey)
+		}
+	}
+	if cidrKey != "" {
+		if a.perCIDR[cidrKey]--; a.perCIDR[cidrKey] <= 0 {
+			delete(a.perCIDR, cidrKey)
+		}
+	}
+}
+
+// countRejected returns the number of the connections rejected by the limits.
+func (a *admission) countRejected() int64 {
+	return atomic.LoadInt64(&a.rejected)
+}
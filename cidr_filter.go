@@ -0,0 +1,108 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// CIDRFilter is a built-in implementation of AcceptFilter which allows or denies the connections by the networks
+// of their source IPs, it can be embedded into the EventHandler and reloaded at runtime without restarting
+// the engine. The connections from the networks in the deny list are rejected, and so are the connections
+// from the networks out of the allow list if it's not empty, the connections of unix sockets are always allowed.
+type CIDRFilter struct {
+	rules atomic.Value // *cidrRules
+}
+
+type cidrRules struct {
+	allow, deny []*net.IPNet
+}
+
+// NewCIDRFilter creates an instance of CIDRFilter with the allow list and the deny list,
+// the entries are in CIDR notation like "192.0.2.0/24" or "2001:db8::/32", or plain IP addresses.
+func NewCIDRFilter(allow, deny []string) (*CIDRFilter, error) {
+	f := new(CIDRFilter)
+	if err := f.Reload(allow, deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload replaces the allow list and the deny list of f, which takes effect for the connections accepted
+// afterwards, the lists are left unchanged if any of the entries is invalid.
+func (f *CIDRFilter) Reload(allow, deny []string) (err error) {
+	rules := new(cidrRules)
+	if rules.allow, err = parseCIDRs(allow); err != nil {
+		return
+	}
+	if rules.deny, err = parseCIDRs(deny); err != nil {
+		return
+	}
+	f.rules.Store(rules)
+	return
+}
+
+// OnAccept implements AcceptFilter.
+func (f *CIDRFilter) OnAccept(remote, _ net.Addr) (action Action) {
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	rules, _ := f.rules.Load().(*cidrRules)
+	if rules == nil {
+		return
+	}
+	if containsIP(rules.deny, tcpAddr.IP) {
+		return Close
+	}
+	if len(rules.allow) > 0 && !containsIP(rules.allow, tcpAddr.IP) {
+		return Close
+	}
+	return
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: cidr}
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -19,13 +19,11 @@ package gnet
 
 import (
 	"context"
-	"errors"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
-	"time"
 
 	"golang.org/x/sys/unix"
 
@@ -38,9 +36,10 @@ import (
 
 // Client of gnet.
 type Client struct {
-	opts     *Options
-	el       *eventloop
-	logFlush func() error
+	opts            *Options
+	el              *eventloop
+	logFlush        func() error
+	fastOpenConnect func(fd, opt int) error
 }
 
 // NewClient creates an instance of Client.
@@ -66,13 +65,13 @@ func NewClient(eventHandler EventHandler, opts ...Option) (cli *Client, err erro
 	eng := new(engine)
 	eng.opts = options
 	eng.eventHandler = eventHandler
-	eng.ln = &listener{network: "udp"}
+	eng.tcpSockOpts = tcpSockOpts(options)
+	cli.fastOpenConnect = optionalSockOpt(options.Logger, "TCP_FASTOPEN_CONNECT", socket.SetFastOpenConnect)
 	eng.cond = sync.NewCond(&sync.Mutex{})
 	if options.Ticker {
 		eng.tickerCtx, eng.cancelTicker = context.WithCancel(context.Background())
 	}
 	el := new(eventloop)
-	el.ln = eng.ln
 	el.engine = eng
 	el.poller = p
 	if rbc := options.ReadBufferCap; rbc <= 0 {
@@ -81,6 +80,7 @@ func NewClient(eventHandler EventHandler, opts ...Option) (cli *Client, err erro
 		options.ReadBufferCap = toolkit.CeilToPowerOfTwo(rbc)
 	}
 	el.buffer = make([]byte, options.ReadBufferCap)
+	el.udpBatch = newUDPBatch(options)
 	el.udpSockets = make(map[int]*conn)
 	el.connections = make(map[int]*conn)
 	el.eventHandler = eventHandler
@@ -122,81 +122,91 @@ func (cli *Client) Stop() (err error) {
 
 // Dial is like net.Dial().
 func (cli *Client) Dial(network, address string) (Conn, error) {
-	c, err := net.Dial(network, address)
-	if err != nil {
-		return nil, err
+	if network == "unixgram" {
+		return cli.dialUnixgram(address)
 	}
-	defer c.Close()
 
-	sc, ok := c.(syscall.Conn)
-	if !ok {
-		return nil, errors.New("failed to convert net.Conn to syscall.Conn")
+	var dialer net.Dialer
+	if cli.opts.TCPFastOpenConnect && strings.HasPrefix(network, "tcp") {
+		dialer.Control = func(_, _ string, rc syscall.RawConn) (err error) {
+			if e := rc.Control(func(fd uintptr) { err = cli.fastOpenConnect(int(fd), 1) }); e != nil {
+				return e
+			}
+			return
+		}
 	}
-	rc, err := sc.SyscallConn()
+	c, err := dialer.Dial(network, address)
 	if err != nil {
-		return nil, errors.New("failed to get syscall.RawConn from net.Conn")
+		return nil, err
 	}
+	defer c.Close()
 
-	var DupFD int
-	e := rc.Control(func(fd uintptr) {
-		DupFD, err = unix.Dup(int(fd))
-	})
+	DupFD, err := dupSyscallConn(c)
 	if err != nil {
 		return nil, err
 	}
-	if e != nil {
-		return nil, e
-	}
 
 	if strings.HasPrefix(network, "tcp") {
 		if cli.opts.TCPNoDelay == TCPDelay {
 			if err = socket.SetNoDelay(DupFD, 0); err != nil {
+				_ = unix.Close(DupFD)
 				return nil, err
 			}
 		}
-		if cli.opts.TCPKeepAlive > 0 {
-			if err = socket.SetKeepAlive(DupFD, int(cli.opts.TCPKeepAlive/time.Second)); err != nil {
-				return nil, err
-			}
+		if err = setSockOpts(DupFD, cli.el.engine.tcpSockOpts); err != nil {
+			_ = unix.Close(DupFD)
+			return nil, err
 		}
 	}
 
-	if cli.opts.SocketSendBuffer > 0 {
-		if err = socket.SetSendBuffer(DupFD, cli.opts.SocketSendBuffer); err != nil {
-			return nil, err
-		}
+	if err = cli.setSocketBuffers(DupFD); err != nil {
+		_ = unix.Close(DupFD)
+		return nil, err
 	}
-	if cli.opts.SocketRecvBuffer > 0 {
-		if err = socket.SetRecvBuffer(DupFD, cli.opts.SocketRecvBuffer); err != nil {
-			return nil, err
-		}
+
+	if err = controlSocket(DupFD, cli.opts.SocketOptions); err != nil {
+		_ = unix.Close(DupFD)
+		return nil, err
 	}
 
-	var (
-		sockAddr unix.Sockaddr
-		gc       Conn
-	)
-	switch c.(type) {
-	case *net.UnixConn:
-		if sockAddr, _, _, err = socket.GetUnixSockAddr(c.RemoteAddr().Network(), c.RemoteAddr().String()); err != nil {
-			return nil, err
-		}
+	if _, ok := c.(*net.UnixConn); ok {
 		ua := c.LocalAddr().(*net.UnixAddr)
 		ua.Name = c.RemoteAddr().String() + "." + strconv.Itoa(DupFD)
-		gc = newTCPConn(DupFD, cli.el, sockAddr, c.LocalAddr(), c.RemoteAddr())
-	case *net.TCPConn:
-		if sockAddr, _, _, _, err = socket.GetTCPSockAddr(c.RemoteAddr().Network(), c.RemoteAddr().String()); err != nil {
-			return nil, err
-		}
-		gc = newTCPConn(DupFD, cli.el, sockAddr, c.LocalAddr(), c.RemoteAddr())
-	case *net.UDPConn:
-		if sockAddr, _, _, _, err = socket.GetUDPSockAddr(c.RemoteAddr().Network(), c.RemoteAddr().String()); err != nil {
-			return nil, err
-		}
-		gc = newUDPConn(DupFD, cli.el, c.LocalAddr(), sockAddr, true)
-	default:
-		return nil, gerrors.ErrUnsupportedProtocol
 	}
+	gc, err := newConnFromNetConn(DupFD, cli.el, c)
+	if err != nil {
+		_ = unix.Close(DupFD)
+		return nil, err
+	}
+	err = cli.el.poller.UrgentTrigger(cli.el.register, gc)
+	if err != nil {
+		gc.Close()
+		return nil, err
+	}
+	return gc, nil
+}
+
+// dialUnixgram connects to the unixgram socket at address, the client socket is bound to an autogenerated
+// abstract address on Linux so that the peer is able to reply to it, which the standard library doesn't do.
+func (cli *Client) dialUnixgram(address string) (Conn, error) {
+	sa, _, raddr, err := socket.GetUnixSockAddr("unixgram", address)
+	if err != nil {
+		return nil, err
+	}
+	fd, laddr, err := socket.UnixSocket("unixgram", address, false)
+	if err != nil {
+		return nil, err
+	}
+	if err = cli.setSocketBuffers(fd); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	if err = controlSocket(fd, cli.opts.SocketOptions); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	gc := newUDPConn(fd, cli.el, laddr, sa, true)
+	gc.remoteAddr = raddr
 	err = cli.el.poller.UrgentTrigger(cli.el.register, gc)
 	if err != nil {
 		gc.Close()
@@ -204,3 +214,17 @@ func (cli *Client) Dial(network, address string) (Conn, error) {
 	}
 	return gc, nil
 }
+
+func (cli *Client) setSocketBuffers(fd int) error {
+	if cli.opts.SocketSendBuffer > 0 {
+		if err := socket.SetSendBuffer(fd, cli.opts.SocketSendBuffer); err != nil {
+			return err
+		}
+	}
+	if cli.opts.SocketRecvBuffer > 0 {
+		if err := socket.SetRecvBuffer(fd, cli.opts.SocketRecvBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
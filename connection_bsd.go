@@ -20,9 +20,26 @@ package gnet
 import "github.com/panjf2000/gnet/v2/internal/netpoll"
 
 func (c *conn) handleEvents(_ int, filter int16) (err error) {
+	// The closing connection only sends the residual data, any error will be caught by flush.
+	if c.closing {
+		return c.loop.flush(c)
+	}
+
 	switch filter {
 	case netpoll.EVFilterSock:
-		err = c.loop.closeConn(c, nil)
+		if !c.loop.engine.opts.HalfClose || c.readEOF {
+			err = c.loop.closeConn(c, nil)
+			break
+		}
+		// Keep the connection open if it's just the end-of-file from the peer, in which case
+		// the pending data is flushed and the leftover data from the peer is read before read
+		// reaches the end-of-file, see eventloop.halfClose.
+		if !c.outboundBuffer.IsEmpty() {
+			if err = c.loop.write(c); err != nil || !c.opened {
+				break
+			}
+		}
+		err = c.loop.read(c)
 	case netpoll.EVFilterWrite:
 		if !c.outboundBuffer.IsEmpty() {
 			err = c.loop.write(c)
@@ -20,6 +20,11 @@ package gnet
 import "github.com/panjf2000/gnet/v2/internal/netpoll"
 
 func (c *conn) handleEvents(_ int, ev uint32) error {
+	// The closing connection only sends the residual data, any error will be caught by flush.
+	if c.closing {
+		return c.loop.flush(c)
+	}
+
 	// Don't change the ordering of processing EPOLLOUT | EPOLLRDHUP / EPOLLIN unless you're 100%
 	// sure what you're doing!
 	// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
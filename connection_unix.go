@@ -19,9 +19,11 @@
 package gnet
 
 import (
+	"errors"
 	"io"
 	"net"
 	"os"
+	"syscall"
 	"time"
 
 	"golang.org/x/sys/unix"
@@ -40,10 +42,22 @@ type conn struct {
 	ctx            interface{}             // user-defined context
 	peer           unix.Sockaddr           // remote socket address
 	loop           *eventloop              // connected event-loop
+	ln             *listener               // listener that accepted the connection, nil for client-side connections
 	cache          *bbPool.ByteBuffer      // temporary buffer in each event-loop
 	buffer         []byte                  // buffer for the latest bytes
 	opened         bool                    // connection opened event fired
-	isDatagram     bool                    // UDP protocol
+	isDatagram     bool                    // UDP or unixgram protocol
+	session        bool                    // UDP session which is owned by the event-loop and outlives OnTraffic
+	activeAt       time.Time               // last time a datagram was received, only for UDP sessions
+	inFds          []int                   // file descriptors received from the peer but not claimed yet
+	outFds         []pendingFds            // file descriptors waiting in the outbound buffer to be sent
+	peerCred       *Credentials            // credentials of the peer, only for unix connections
+	admitted       bool                    // the connection is counted in by the connection limits of the engine
+	readEOF        bool                    // the peer has shut down its writing side, only with Options.HalfClose
+	writeClosed    bool                    // the writing side is shut down, or about to be after the outbound buffer is flushed
+	closing        bool                    // the connection is about to be closed after the outbound buffer is flushed
+	closeErr       error                   // error passed to OnClose when the closing connection is closed
+	flushTimer     *time.Timer             // timer for closing the closing connection when it fails to flush in time
 	localAddr      net.Addr                // local addr
 	remoteAddr     net.Addr                // remote addr
 	inboundBuffer  elastic.RingBuffer      // buffer for leftover data from the peer
@@ -66,16 +80,28 @@ func newTCPConn(fd int, el *eventloop, sa unix.Sockaddr, localAddr, remoteAddr n
 }
 
 func (c *conn) releaseTCP() {
+	if c.admitted {
+		c.loop.engine.admission.release(c.remoteAddr)
+		c.admitted = false
+	}
 	c.opened = false
 	c.peer = nil
 	c.ctx = nil
 	c.buffer = nil
-	if addr, ok := c.localAddr.(*net.TCPAddr); ok && c.localAddr != c.loop.ln.addr {
+	c.releaseFds()
+	c.peerCred = nil
+	c.readEOF = false
+	c.writeClosed = false
+	c.closing = false
+	c.closeErr = nil
+	c.flushTimer = nil
+	if addr, ok := c.localAddr.(*net.TCPAddr); ok && (c.ln == nil || c.localAddr != c.ln.addr) {
 		bsPool.Put(addr.IP)
 	}
 	if addr, ok := c.remoteAddr.(*net.TCPAddr); ok {
 		bsPool.Put(addr.IP)
 	}
+	c.ln = nil
 	c.localAddr = nil
 	c.remoteAddr = nil
 	c.inboundBuffer.Done()
@@ -95,20 +121,83 @@ func newUDPConn(fd int, el *eventloop, localAddr net.Addr, sa unix.Sockaddr, con
 		remoteAddr: socket.SockaddrToUDPAddr(sa),
 		isDatagram: true,
 	}
+	if sa, ok := sa.(*unix.SockaddrUnix); ok {
+		c.remoteAddr = &net.UnixAddr{Name: sa.Name, Net: "unixgram"}
+	}
 	if connected {
 		c.peer = nil
 	}
 	return
 }
 
+// newConnFromNetConn makes up a connection with fd which is a duplicate of the socket underlying nc.
+func newConnFromNetConn(fd int, el *eventloop, nc net.Conn) (c *conn, err error) {
+	var sa unix.Sockaddr
+	switch nc.(type) {
+	case *net.UnixConn:
+		if sa, _, _, err = socket.GetUnixSockAddr(nc.RemoteAddr().Network(), nc.RemoteAddr().String()); err != nil {
+			return
+		}
+		if nc.RemoteAddr().Network() == "unixgram" {
+			c = newUDPConn(fd, el, nc.LocalAddr(), sa, true)
+			break
+		}
+		c = newTCPConn(fd, el, sa, nc.LocalAddr(), nc.RemoteAddr())
+	case *net.TCPConn:
+		if sa, _, _, _, err = socket.GetTCPSockAddr(nc.RemoteAddr().Network(), nc.RemoteAddr().String()); err != nil {
+			return
+		}
+		c = newTCPConn(fd, el, sa, nc.LocalAddr(), nc.RemoteAddr())
+	case *net.UDPConn:
+		if sa, _, _, _, err = socket.GetUDPSockAddr(nc.RemoteAddr().Network(), nc.RemoteAddr().String()); err != nil {
+			return
+		}
+		c = newUDPConn(fd, el, nc.LocalAddr(), sa, true)
+	default:
+		err = gerrors.ErrUnsupportedProtocol
+	}
+	return
+}
+
+// dupSyscallConn returns a non-blocking and close-on-exec copy of the file descriptor underlying v,
+// it's used for adopting the sockets created by the standard library.
+func dupSyscallConn(v interface{}) (dupFD int, err error) {
+	c, ok := v.(syscall.Conn)
+	if !ok {
+		return -1, errors.New("failed to convert to syscall.Conn")
+	}
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return -1, errors.New("failed to get syscall.RawConn")
+	}
+
+	var sc string
+	e := rc.Control(func(fd uintptr) {
+		dupFD, sc, err = netpoll.Dup(int(fd))
+	})
+	if e != nil {
+		return -1, e
+	}
+	if err != nil {
+		return -1, os.NewSyscallError(sc, err)
+	}
+	if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(dupFD, true)); err != nil {
+		_ = unix.Close(dupFD)
+		return -1, err
+	}
+	return
+}
+
 func (c *conn) releaseUDP() {
 	c.ctx = nil
-	if addr, ok := c.localAddr.(*net.UDPAddr); ok && c.localAddr != c.loop.ln.addr {
+	// The local addresses of the datagrams received on the listeners are cached by the listeners.
+	if addr, ok := c.localAddr.(*net.UDPAddr); ok && c.ln == nil {
 		bsPool.Put(addr.IP)
 	}
 	if addr, ok := c.remoteAddr.(*net.UDPAddr); ok {
 		bsPool.Put(addr.IP)
 	}
+	c.ln = nil
 	c.localAddr = nil
 	c.remoteAddr = nil
 	c.buffer = nil
@@ -131,6 +220,13 @@ func (c *conn) open(buf []byte) error {
 }
 
 func (c *conn) write(data []byte) (err error) {
+	if c.closing {
+		return gerrors.ErrConnectionClosed
+	}
+	if c.writeClosed {
+		return gerrors.ErrWriteClosed
+	}
+
 	// If there is pending data in outbound buffer, the current data ought to be appended to the outbound buffer
 	// for maintaining the sequence of network packets.
 	if !c.outboundBuffer.IsEmpty() {
@@ -143,7 +239,7 @@ func (c *conn) write(data []byte) (err error) {
 		// A temporary error occurs, append the data to outbound buffer, writing it back to the peer in the next round.
 		if err == unix.EAGAIN {
 			_, _ = c.outboundBuffer.Write(data)
-			err = c.loop.poller.ModReadWrite(c.pollAttachment)
+			err = c.watchWrite()
 			return
 		}
 		return c.loop.closeConn(c, os.NewSyscallError("write", err))
@@ -151,12 +247,19 @@ func (c *conn) write(data []byte) (err error) {
 	// Failed to send all data back to the peer, buffer the leftover data for the next round.
 	if n < len(data) {
 		_, _ = c.outboundBuffer.Write(data[n:])
-		err = c.loop.poller.ModReadWrite(c.pollAttachment)
+		err = c.watchWrite()
 	}
 	return
 }
 
 func (c *conn) writev(bs [][]byte) (err error) {
+	if c.closing {
+		return gerrors.ErrConnectionClosed
+	}
+	if c.writeClosed {
+		return gerrors.ErrWriteClosed
+	}
+
 	var cum int
 	for _, b := range bs {
 		cum += len(b)
@@ -174,7 +277,7 @@ func (c *conn) writev(bs [][]byte) (err error) {
 		// A temporary error occurs, append the data to outbound buffer, writing it back to the peer in the next round.
 		if err == unix.EAGAIN {
 			_, _ = c.outboundBuffer.Writev(bs)
-			err = c.loop.poller.ModReadWrite(c.pollAttachment)
+			err = c.watchWrite()
 			return
 		}
 		return c.loop.closeConn(c, os.NewSyscallError("write", err))
@@ -192,16 +295,36 @@ func (c *conn) writev(bs [][]byte) (err error) {
 			n -= bn
 		}
 		_, _ = c.outboundBuffer.Writev(bs[pos:])
-		err = c.loop.poller.ModReadWrite(c.pollAttachment)
+		err = c.watchWrite()
 	}
 	return
 }
 
+// watchWrite starts watching the writable events of the connection to flush the outbound buffer.
+func (c *conn) watchWrite() error {
+	if c.readEOF {
+		return c.loop.poller.ModWrite(c.pollAttachment)
+	}
+	return c.loop.poller.ModReadWrite(c.pollAttachment)
+}
+
+// unwatchWrite stops watching the writable events of the connection after the outbound buffer is flushed.
+func (c *conn) unwatchWrite() error {
+	if c.readEOF {
+		return c.loop.poller.ModNone(c.pollAttachment)
+	}
+	return c.loop.poller.ModRead(c.pollAttachment)
+}
+
 func (c *conn) asyncWrite(itf interface{}) error {
 	if !c.opened {
 		return nil
 	}
 
+	if c.isDatagram {
+		c.asyncWriteTo(itf.([]byte))
+		return nil
+	}
 	return c.write(itf.([]byte))
 }
 
@@ -210,16 +333,57 @@ func (c *conn) asyncWritev(itf interface{}) error {
 		return nil
 	}
 
+	if c.isDatagram {
+		for _, b := range itf.([][]byte) {
+			c.asyncWriteTo(b)
+		}
+		return nil
+	}
 	return c.writev(itf.([][]byte))
 }
 
+// asyncWriteTo sends the datagram written by AsyncWrite to a UDP session, the error is only logged
+// since it can't be returned to the caller.
+func (c *conn) asyncWriteTo(buf []byte) {
+	if err := c.writeTo(buf); err != nil {
+		c.loop.getLogger().Debugf("failed to send UDP packet to %v in event-loop(%d), %v", c.remoteAddr, c.loop.idx, err)
+	}
+}
+
+// writeTo is like sendTo but the datagram may be queued and sent along with others in a batch,
+// it must be called in the event-loop.
+func (c *conn) writeTo(buf []byte) error {
+	// The unixgram datagrams are sent right away since they can't be coalesced with UDP GSO.
+	if _, ok := c.localAddr.(*net.UDPAddr); ok && c.loop.queueUDP(c.fd, c.peer, c.sourceIP(), buf) {
+		return nil
+	}
+	return c.sendTo(buf)
+}
+
 func (c *conn) sendTo(buf []byte) error {
 	if c.peer == nil {
 		return unix.Send(c.fd, buf, 0)
 	}
+	if src := c.sourceIP(); src != nil {
+		oob := bsPool.Get(socket.PktInfoCmsgSpace)
+		defer bsPool.Put(oob)
+		return unix.Sendmsg(c.fd, buf, oob[:socket.PutPktInfo(oob, src)], c.peer, 0)
+	}
 	return unix.Sendto(c.fd, buf, 0, c.peer)
 }
 
+// sourceIP returns the local IP address of the datagram received on the UDP listener bound to a wildcard
+// address, which is used as the source address of the replies, or nil if it's unknown.
+func (c *conn) sourceIP() []byte {
+	if c.ln == nil || c.ln.oob == nil || c.localAddr == c.ln.addr {
+		return nil
+	}
+	if addr, ok := c.localAddr.(*net.UDPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
 func (c *conn) resetBuffer() {
 	c.buffer = c.buffer[:0]
 	c.inboundBuffer.Reset()
@@ -321,7 +485,7 @@ func (c *conn) Discard(n int) (int, error) {
 
 func (c *conn) Write(p []byte) (n int, err error) {
 	if c.isDatagram {
-		return len(p), c.sendTo(p)
+		return len(p), c.writeTo(p)
 	}
 	return len(p), c.write(p)
 }
@@ -337,7 +501,7 @@ func (c *conn) Writev(bs [][]byte) (n int, err error) {
 			copy(buf[m:], b)
 			m += len(b)
 		}
-		err = c.sendTo(buf)
+		err = c.writeTo(buf)
 		bsPool.Put(buf)
 		return
 	}
@@ -399,17 +563,25 @@ func (c *conn) SetContext(ctx interface{}) { c.ctx = ctx }
 func (c *conn) LocalAddr() net.Addr        { return c.localAddr }
 func (c *conn) RemoteAddr() net.Addr       { return c.remoteAddr }
 
+func (c *conn) ListenerID() int {
+	if c.ln == nil {
+		return -1
+	}
+	return c.ln.id
+}
+
 // ==================================== Concurrency-safe API's ====================================
 
 func (c *conn) AsyncWrite(buf []byte) error {
-	if c.isDatagram {
+	// The UDP sessions are owned by the event-loop, which may update or release them at any time.
+	if c.isDatagram && !c.session {
 		return c.sendTo(buf)
 	}
 	return c.loop.poller.Trigger(c.asyncWrite, buf)
 }
 
 func (c *conn) AsyncWritev(bs [][]byte) error {
-	if c.isDatagram {
+	if c.isDatagram && !c.session {
 		for _, b := range bs {
 			if err := c.sendTo(b); err != nil {
 				return err
@@ -427,3 +599,21 @@ func (c *conn) Wake() error {
 func (c *conn) Close() error {
 	return c.loop.poller.Trigger(func(_ interface{}) error { return c.loop.closeConn(c, nil) }, nil)
 }
+
+func (c *conn) CloseWithError(reason error) error {
+	return c.loop.poller.Trigger(func(_ interface{}) error { return c.loop.closeConn(c, reason) }, nil)
+}
+
+func (c *conn) Abort() error {
+	if c.isDatagram {
+		return gerrors.ErrUnsupportedOp
+	}
+	return c.loop.poller.Trigger(func(_ interface{}) error { return c.loop.abortConn(c) }, nil)
+}
+
+func (c *conn) CloseWrite() error {
+	if c.isDatagram {
+		return gerrors.ErrUnsupportedOp
+	}
+	return c.loop.poller.Trigger(func(_ interface{}) error { return c.loop.closeWrite(c) }, nil)
+}
@@ -21,15 +21,18 @@ package gnet
 import (
 	"context"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/panjf2000/gnet/v2/internal/netpoll"
+	"github.com/panjf2000/gnet/v2/internal/socket"
 	"github.com/panjf2000/gnet/v2/pkg/errors"
 )
 
 type engine struct {
-	ln           *listener          // the listener for accepting new connections
+	listeners    []*listener        // the listeners for accepting new connections
 	lb           loadBalancer       // event-loops for handling events
 	wg           sync.WaitGroup     // event-loop close WaitGroup
 	opts         *Options           // options with engine
@@ -37,15 +40,181 @@ type engine struct {
 	cond         *sync.Cond         // shutdown signaler
 	mainLoop     *eventloop         // main event-loop for accepting connections
 	inShutdown   int32              // whether the engine is in shutdown
+	inDrain      int32              // whether the engine is draining connections
 	tickerCtx    context.Context    // context for ticker
 	cancelTicker context.CancelFunc // function to stop the ticker
 	eventHandler EventHandler       // user eventHandler
+	tcpSockOpts  []socket.Option    // socket options applied to TCP connections
+	admission    *admission         // limits of the concurrent connections, nil if there are no limits
+	acceptLimit  *rateLimiter       // rate limiter of accepting connections, nil if it's unlimited
 }
 
 func (eng *engine) isInShutdown() bool {
 	return atomic.LoadInt32(&eng.inShutdown) == 1
 }
 
+func (eng *engine) isDraining() bool {
+	return atomic.LoadInt32(&eng.inDrain) == 1
+}
+
+// drain stops accepting new connections, notifies all active connections of the upcoming shutdown
+// and then waits until all connections are closed or ctx is done.
+func (eng *engine) drain(ctx context.Context) {
+	if !atomic.CompareAndSwapInt32(&eng.inDrain, 0, 1) {
+		return
+	}
+
+	// The listeners are closed on all event-loops before any connection is notified,
+	// so that the peers are unable to reconnect to the engine after the notices.
+	var wg sync.WaitGroup
+	drain := func(el *eventloop) error {
+		wg.Add(1)
+		err := el.poller.UrgentTrigger(func(_ interface{}) error {
+			defer wg.Done()
+			return el.drain(nil)
+		}, nil)
+		if err != nil {
+			wg.Done()
+		}
+		return err
+	}
+	if eng.mainLoop != nil {
+		if err := drain(eng.mainLoop); err != nil {
+			eng.opts.Logger.Errorf("failed to call UrgentTrigger on main event-loop when draining engine: %v", err)
+		}
+	}
+	eng.lb.iterate(func(i int, el *eventloop) bool {
+		if err := drain(el); err != nil {
+			eng.opts.Logger.Errorf("failed to call UrgentTrigger on sub event-loop when draining engine: %v", err)
+		}
+		return true
+	})
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for waiting := true; waiting; {
+		select {
+		case <-drained:
+			waiting = false
+		case <-ctx.Done():
+			eng.opts.Logger.Warnf("engine stops draining connections due to %v", ctx.Err())
+			return
+		case <-ticker.C:
+			if eng.isInShutdown() { // the event-loops may exit without running the pending tasks
+				return
+			}
+		}
+	}
+
+	eng.lb.iterate(func(i int, el *eventloop) bool {
+		err := el.poller.UrgentTrigger(el.notifyShutdown, nil)
+		if err != nil {
+			eng.opts.Logger.Errorf("failed to call UrgentTrigger on sub event-loop when draining engine: %v", err)
+		}
+		return true
+	})
+
+	for (Engine{eng}).CountConnections() > 0 {
+		select {
+		case <-ctx.Done():
+			eng.opts.Logger.Warnf("engine stops draining connections due to %v", ctx.Err())
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pauseAccept pauses/resumes accepting connections on the main reactor and all event-loops, it waits until
+// every event-loop has done it, and the event-loops that have done it are restored if any of them fails.
+func (eng *engine) pauseAccept(pause bool) error {
+	if eng.isInShutdown() || eng.isDraining() {
+		return errors.ErrEngineInShutdown
+	}
+	var loops []*eventloop
+	if eng.mainLoop != nil {
+		loops = append(loops, eng.mainLoop)
+	}
+	eng.lb.iterate(func(i int, el *eventloop) bool {
+		loops = append(loops, el)
+		return true
+	})
+
+	var (
+		err  error
+		done []*eventloop
+	)
+	for i, e := range eng.runOnLoops(loops, func(el *eventloop) error { return el.pauseAccept(pause) }) {
+		if e != nil {
+			err = appendError(err, e)
+		} else {
+			done = append(done, loops[i])
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	for i, e := range eng.runOnLoops(done, func(el *eventloop) error { return el.pauseAccept(!pause) }) {
+		if e != nil {
+			eng.opts.Logger.Errorf("failed to restore accepting in event-loop(%d): %v", done[i].idx, e)
+		}
+	}
+	return err
+}
+
+// runOnLoops runs task inside each of loops and waits for the results, the result of an event-loop is
+// errors.ErrEngineInShutdown if the engine is shut down before the task gets to run.
+func (eng *engine) runOnLoops(loops []*eventloop, task func(*eventloop) error) []error {
+	results := make([]chan error, len(loops))
+	for i, el := range loops {
+		el, result := el, make(chan error, 1)
+		results[i] = result
+		err := el.poller.UrgentTrigger(func(_ interface{}) error {
+			result <- task(el)
+			return nil
+		}, nil)
+		if err != nil {
+			result <- err
+		}
+	}
+
+	errs := make([]error, len(loops))
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for i, result := range results {
+		for waiting := true; waiting; {
+			select {
+			case errs[i] = <-result:
+				waiting = false
+			case <-ticker.C:
+				if eng.isInShutdown() { // the event-loops may exit without running the pending tasks
+					errs[i], waiting = errors.ErrEngineInShutdown, false
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// awaitShutdown waits until the engine has been shut down or ctx is done.
+func (eng *engine) awaitShutdown(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if eng.isInShutdown() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
 // waitForShutdown waits for a signal to shut down.
 func (eng *engine) waitForShutdown() {
 	eng.cond.L.Lock()
@@ -91,29 +260,63 @@ func (eng *engine) startSubReactors() {
 	})
 }
 
+// listenerFor returns the listener of ln for the event-loop with index i, the event-loop 0 takes ln itself
+// while the others get a new socket listening on the same address or a duplicate of ln.
+func (eng *engine) listenerFor(i int, ln *listener) (l *listener, err error) {
+	switch {
+	case i == 0:
+		l = ln
+	case ln.activated, ln.adopted, ln.multicast, strings.HasPrefix(ln.network, "unix"):
+		// The sockets passed by the service manager or adopted from net.Listener can't be recreated,
+		// the unix socket can't be bound more than once and every multicast socket would receive its own
+		// copy of each datagram, share the socket among event-loops instead.
+		l, err = ln.clone()
+	default:
+		l, err = initListener(ln.network, ln.address, eng.opts)
+	}
+	if err != nil {
+		return
+	}
+	l.id = ln.id
+	if l.network == "udp" && eng.opts.UDPGRO && eng.opts.UDPBatchSize > 1 {
+		if e := socket.SetUDPGRO(l.fd, 1); e != nil {
+			eng.opts.Logger.Warnf("UDP GRO is disabled on %s due to %v", l.address, e)
+		}
+	}
+	if l.network == "udp" {
+		if e := l.enablePktInfo(); e != nil {
+			eng.opts.Logger.Debugf("packet information is disabled on %s due to %v", l.address, e)
+		}
+	}
+	return
+}
+
 func (eng *engine) activateEventLoops(numEventLoop int) (err error) {
-	network, address := eng.ln.network, eng.ln.address
-	ln := eng.ln
-	eng.ln = nil
 	var striker *eventloop
 	// Create loops locally and bind the listeners.
 	for i := 0; i < numEventLoop; i++ {
-		if i > 0 {
-			if ln, err = initListener(network, address, eng.opts); err != nil {
-				return
-			}
-		}
 		var p *netpoll.Poller
 		if p, err = netpoll.OpenPoller(); err == nil {
 			el := new(eventloop)
-			el.ln = ln
+			el.listeners = make(map[int]*listener, len(eng.listeners))
 			el.engine = eng
 			el.poller = p
 			el.buffer = make([]byte, eng.opts.ReadBufferCap)
+			el.udpBatch = newUDPBatch(eng.opts)
+			el.udpSockets = make(map[int]*conn)
+			if eng.opts.UDPSessionTimeout > 0 {
+				el.udpSessions = make(map[udpSessionKey]*conn)
+			}
 			el.connections = make(map[int]*conn)
 			el.eventHandler = eng.eventHandler
-			if err = el.poller.AddRead(el.ln.packPollAttachment(el.accept)); err != nil {
-				return
+			for _, ln := range eng.listeners {
+				var l *listener
+				if l, err = eng.listenerFor(i, ln); err != nil {
+					return
+				}
+				if err = el.addListener(l, el.accept); err != nil {
+					return
+				}
 			}
 			eng.lb.register(el)
 
@@ -138,12 +341,31 @@ func (eng *engine) activateReactors(numEventLoop int) error {
 	for i := 0; i < numEventLoop; i++ {
 		if p, err := netpoll.OpenPoller(); err == nil {
 			el := new(eventloop)
-			el.ln = eng.ln
+			el.listeners = make(map[int]*listener)
 			el.engine = eng
 			el.poller = p
 			el.buffer = make([]byte, eng.opts.ReadBufferCap)
+			el.udpBatch = newUDPBatch(eng.opts)
+			el.udpSockets = make(map[int]*conn)
+			if eng.opts.UDPSessionTimeout > 0 {
+				el.udpSessions = make(map[udpSessionKey]*conn)
+			}
 			el.connections = make(map[int]*conn)
 			el.eventHandler = eng.eventHandler
+			// Datagrams can't be accepted by the main reactor, so the UDP and unixgram listeners are served by
+			// sub reactors.
+			for _, ln := range eng.listeners {
+				if !ln.isDatagram() {
+					continue
+				}
+				l, err := eng.listenerFor(i, ln)
+				if err != nil {
+					return err
+				}
+				if err = el.addListener(l, el.accept); err != nil {
+					return err
+				}
+			}
 			eng.lb.register(el)
 		} else {
 			return err
@@ -155,13 +377,18 @@ func (eng *engine) activateReactors(numEventLoop int) error {
 
 	if p, err := netpoll.OpenPoller(); err == nil {
 		el := new(eventloop)
-		el.ln = eng.ln
+		el.listeners = make(map[int]*listener)
 		el.idx = -1
 		el.engine = eng
 		el.poller = p
 		el.eventHandler = eng.eventHandler
-		if err = el.poller.AddRead(eng.ln.packPollAttachment(eng.accept)); err != nil {
-			return err
+		for _, ln := range eng.listeners {
+			if ln.isDatagram() {
+				continue
+			}
+			if err = el.addListener(ln, eng.accept); err != nil {
+				return err
+			}
 		}
 		eng.mainLoop = el
 
@@ -184,11 +411,15 @@ func (eng *engine) activateReactors(numEventLoop int) error {
 }
 
 func (eng *engine) start(numEventLoop int) error {
-	if eng.opts.ReusePort || eng.ln.network == "udp" {
+	if eng.opts.ReusePort {
 		return eng.activateEventLoops(numEventLoop)
 	}
-
-	return eng.activateReactors(numEventLoop)
+	for _, ln := range eng.listeners {
+		if !ln.isDatagram() {
+			return eng.activateReactors(numEventLoop)
+		}
+	}
+	return eng.activateEventLoops(numEventLoop)
 }
 
 func (eng *engine) stop(s Engine) {
@@ -207,8 +438,12 @@ func (eng *engine) stop(s Engine) {
 	})
 
 	if eng.mainLoop != nil {
-		eng.ln.close()
-		err := eng.mainLoop.poller.UrgentTrigger(func(_ interface{}) error { return errors.ErrEngineShutdown }, nil)
+		// The listeners of the main event-loop are closed inside the event-loop, otherwise the pending
+		// events of the closed listeners could be handled by accepting on the reused file descriptors.
+		err := eng.mainLoop.poller.UrgentTrigger(func(_ interface{}) error {
+			eng.mainLoop.closeListeners()
+			return errors.ErrEngineShutdown
+		}, nil)
 		if err != nil {
 			eng.opts.Logger.Errorf("failed to call UrgentTrigger on main event-loop when stopping engine: %v", err)
 		}
@@ -220,6 +455,8 @@ func (eng *engine) stop(s Engine) {
 	eng.closeEventLoops()
 
 	if eng.mainLoop != nil {
+		// The main event-loop may have exited due to an error before running the task above.
+		eng.mainLoop.closeListeners()
 		err := eng.mainLoop.poller.Close()
 		if err != nil {
 			eng.opts.Logger.Errorf("failed to close poller when stopping engine: %v", err)
@@ -234,7 +471,7 @@ func (eng *engine) stop(s Engine) {
 	atomic.StoreInt32(&eng.inShutdown, 1)
 }
 
-func serve(eventHandler EventHandler, listener *listener, options *Options, protoAddr string) error {
+func serve(eventHandler EventHandler, listeners []*listener, options *Options, protoAddrs []string) error {
 	// Figure out the proper number of event-loops/goroutines to run.
 	numEventLoop := 1
 	if options.Multicore {
@@ -247,7 +484,10 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 	eng := new(engine)
 	eng.opts = options
 	eng.eventHandler = eventHandler
-	eng.ln = listener
+	eng.listeners = listeners
+	eng.tcpSockOpts = tcpSockOpts(options)
+	eng.admission = newAdmission(options)
+	eng.acceptLimit = newRateLimiter(options.AcceptRate, options.AcceptBurst)
 
 	switch options.LB {
 	case RoundRobin:
@@ -275,9 +515,24 @@ func serve(eventHandler EventHandler, listener *listener, options *Options, prot
 		eng.opts.Logger.Errorf("gnet engine is stopping with error: %v", err)
 		return err
 	}
+	// All listeners have been set up, the remaining inherited listeners of the same addresses are no longer needed.
+	closeInheritedFds(eng.listeners)
+	for _, ln := range eng.listeners {
+		if ln.inherited {
+			notifyRestartReady()
+			break
+		}
+	}
+	defer func() {
+		for _, protoAddr := range protoAddrs {
+			allEngines.Delete(protoAddr)
+		}
+	}()
 	defer eng.stop(e)
 
-	allEngines.Store(protoAddr, eng)
+	for _, protoAddr := range protoAddrs {
+		allEngines.Store(protoAddr, eng)
+	}
 
 	return nil
 }
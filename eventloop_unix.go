@@ -23,7 +23,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"strings"
 	"sync/atomic"
 	"time"
 
@@ -31,20 +30,29 @@ import (
 
 	"github.com/panjf2000/gnet/v2/internal/io"
 	"github.com/panjf2000/gnet/v2/internal/netpoll"
+	"github.com/panjf2000/gnet/v2/internal/socket"
 	gerrors "github.com/panjf2000/gnet/v2/pkg/errors"
 	"github.com/panjf2000/gnet/v2/pkg/logging"
 )
 
 type eventloop struct {
-	ln           *listener       // listener
-	idx          int             // loop index in the engine loops list
-	engine       *engine         // engine in loop
-	poller       *netpoll.Poller // epoll or kqueue
-	buffer       []byte          // read packet buffer whose capacity is set by user, default value is 64KB
-	connCount    int32           // number of active connections in event-loop
-	udpSockets   map[int]*conn   // client-side UDP socket map: fd -> conn
-	connections  map[int]*conn   // TCP connection map: fd -> conn
-	eventHandler EventHandler    // user eventHandler
+	listeners       map[int]*listener       // listeners owned by event-loop: fd -> listener
+	idx             int                     // loop index in the engine loops list
+	engine          *engine                 // engine in loop
+	poller          *netpoll.Poller         // epoll or kqueue
+	buffer          []byte                  // read packet buffer whose capacity is set by user, default value is 64KB
+	udpBatch        *udpBatch               // buffers for batched UDP I/O, nil if it's disabled
+	oob             []byte                  // buffer for the file descriptors received on unix connections
+	connCount       int32                   // number of active connections in event-loop
+	udpSockets      map[int]*conn           // connected UDP socket map: fd -> conn
+	udpSessions     map[udpSessionKey]*conn // UDP session map: peer -> conn, nil if sessions are disabled
+	udpSessionTimer *time.Timer             // timer for expiring the idle UDP sessions
+	connections     map[int]*conn           // TCP connection map: fd -> conn
+	eventHandler    EventHandler            // user eventHandler
+
+	lnAttachments   map[int]*netpoll.PollAttachment // attachments of the listeners registered on the poller: fd -> attachment
+	acceptPaused    bool                            // accepting is paused by Engine.PauseAccept
+	acceptThrottled bool                            // accepting is paused by the rate limiter until the tokens are refilled
 }
 
 func (el *eventloop) getLogger() logging.Logger {
@@ -62,11 +70,27 @@ func (el *eventloop) loadConn() int32 {
 func (el *eventloop) closeAllSockets() {
 	// Close loops and all outstanding connections
 	for _, c := range el.connections {
-		_ = el.closeConn(c, nil)
+		// Try sending the residual data once, the leftover is discarded since the event-loop is exiting.
+		if !c.outboundBuffer.IsEmpty() {
+			_ = el.flush(c)
+		}
+		_ = el.dropConn(c, nil)
 	}
 	for _, c := range el.udpSockets {
 		_ = el.closeConn(c, nil)
 	}
+	for _, c := range el.udpSessions {
+		_ = el.closeConn(c, nil)
+	}
+	if el.udpSessionTimer != nil {
+		el.udpSessionTimer.Stop()
+	}
+}
+
+func (el *eventloop) closeListeners() {
+	for _, ln := range el.listeners {
+		ln.close()
+	}
 }
 
 func (el *eventloop) register(itf interface{}) error {
@@ -103,6 +127,12 @@ func (el *eventloop) open(c *conn) error {
 		}
 	}
 
+	if el.engine.isDraining() {
+		if notifier, ok := el.eventHandler.(ShutdownNotifier); ok {
+			notifier.OnShutdownNotice(c)
+		}
+	}
+
 	if !c.outboundBuffer.IsEmpty() {
 		if err := el.poller.AddWrite(c.pollAttachment); err != nil {
 			return err
@@ -112,12 +142,20 @@ func (el *eventloop) open(c *conn) error {
 	return el.handleAction(c, action)
 }
 
-func (el *eventloop) read(c *conn) error {
-	n, err := unix.Read(c.fd, el.buffer)
+func (el *eventloop) read(c *conn) (err error) {
+	var n int
+	if c.isUnixStream() {
+		n, err = el.recvmsg(c)
+	} else {
+		n, err = unix.Read(c.fd, el.buffer)
+	}
 	if n == 0 || err != nil {
 		if err == unix.EAGAIN {
 			return nil
 		}
+		if n == 0 && err == nil && el.engine.opts.HalfClose && !c.readEOF {
+			return el.halfClose(c)
+		}
 		return el.closeConn(c, os.NewSyscallError("read", err))
 	}
 
@@ -139,6 +177,13 @@ func (el *eventloop) read(c *conn) error {
 
 	_, _ = c.inboundBuffer.Write(c.buffer)
 
+	// The file descriptors that haven't been claimed by the time all the data they
+	// were sent along with is consumed are no longer needed.
+	if len(c.inFds) > 0 && c.inboundBuffer.IsEmpty() {
+		closeFds(c.inFds)
+		c.inFds = nil
+	}
+
 	return nil
 }
 
@@ -147,15 +192,18 @@ const (
 	MaxBytesToWritePerLoop = 64 * 1024
 	// MaxIovSize is IOV_MAX.
 	MaxIovSize = 1024
+	// DefaultCloseFlushTimeout is the default value of Options.CloseFlushTimeout.
+	DefaultCloseFlushTimeout = 10 * time.Second
 )
 
 func (el *eventloop) write(c *conn) error {
-	iov := c.outboundBuffer.Peek(MaxBytesToWritePerLoop)
 	var (
 		n   int
 		err error
 	)
-	if len(iov) > 1 {
+	if len(c.outFds) > 0 {
+		n, err = c.writeWithFds()
+	} else if iov := c.outboundBuffer.Peek(MaxBytesToWritePerLoop); len(iov) > 1 {
 		if len(iov) > MaxIovSize {
 			iov = iov[:MaxIovSize]
 		}
@@ -169,22 +217,88 @@ func (el *eventloop) write(c *conn) error {
 	case unix.EAGAIN:
 		return nil
 	default:
-		return el.closeConn(c, os.NewSyscallError("write", err))
+		return el.dropConn(c, os.NewSyscallError("write", err))
 	}
 
 	// All data have been drained, it's no need to monitor the writable events,
 	// remove the writable event from poller to help the future event-loops.
 	if c.outboundBuffer.IsEmpty() {
-		_ = el.poller.ModRead(c.pollAttachment)
+		_ = c.unwatchWrite()
+		if c.writeClosed {
+			return el.shutdownWrite(c)
+		}
+	}
+
+	return nil
+}
+
+// halfClose keeps the connection open after the peer has shut down its writing side, see Options.HalfClose.
+func (el *eventloop) halfClose(c *conn) error {
+	c.readEOF = true
+	// Stop watching the readable events which would keep firing after the end-of-file.
+	var err error
+	if c.outboundBuffer.IsEmpty() {
+		err = el.poller.ModNone(c.pollAttachment)
+	} else {
+		err = el.poller.ModWrite(c.pollAttachment)
+	}
+	if err != nil {
+		return el.closeConn(c, err)
+	}
+
+	if notifier, ok := el.eventHandler.(ReadEOFNotifier); ok {
+		// The leftover data of the last read has been moved to the inbound buffer.
+		c.buffer = c.buffer[:0]
+		if err = el.handleAction(c, notifier.OnReadEOF(c)); err != nil || !c.opened {
+			return err
+		}
+	}
+
+	// The writing side has been shut down before, nothing else can be done with the connection.
+	if c.writeClosed && c.outboundBuffer.IsEmpty() {
+		return el.closeConn(c, nil)
+	}
+	return nil
+}
+
+// closeWrite marks the writing side of the connection as closed and shuts it down right away
+// if there is no pending data in the outbound buffer, otherwise it's shut down by write after
+// the outbound buffer is flushed.
+func (el *eventloop) closeWrite(c *conn) error {
+	if !c.opened || c.writeClosed || c.closing {
+		return nil
+	}
+	c.writeClosed = true
+	if c.outboundBuffer.IsEmpty() {
+		return el.shutdownWrite(c)
 	}
+	return nil
+}
 
+// shutdownWrite shuts down the writing side of the connection, which is closed if the peer
+// has shut down its writing side as well.
+func (el *eventloop) shutdownWrite(c *conn) error {
+	if err := unix.Shutdown(c.fd, unix.SHUT_WR); err != nil {
+		return el.closeConn(c, os.NewSyscallError("shutdown", err))
+	}
+	if c.readEOF {
+		return el.closeConn(c, nil)
+	}
 	return nil
 }
 
 func (el *eventloop) closeConn(c *conn, err error) (rerr error) {
-	if addr := c.localAddr; addr != nil && strings.HasPrefix(c.localAddr.Network(), "udp") {
-		rerr = el.poller.Delete(c.fd)
-		if c.fd != el.ln.fd {
+	if c.localAddr != nil && c.isDatagram {
+		if _, ok := el.listeners[c.fd]; ok {
+			// Only the sessions can be closed, the one-off connections of the datagram listener are
+			// released right after OnTraffic.
+			if !c.opened {
+				return
+			}
+			el.closeUDPSession(c)
+		} else {
+			el.discardUDP(c.fd)
+			rerr = el.poller.Delete(c.fd)
 			rerr = unix.Close(c.fd)
 			delete(el.udpSockets, c.fd)
 		}
@@ -199,19 +313,24 @@ func (el *eventloop) closeConn(c *conn, err error) (rerr error) {
 		return
 	}
 
-	// Send residual data in buffer back to the peer before actually closing the connection.
+	// Send residual data in buffer back to the peer before actually closing the connection,
+	// the connection is closed by flush once the outbound buffer is drained.
 	if !c.outboundBuffer.IsEmpty() {
-		for !c.outboundBuffer.IsEmpty() {
-			iov := c.outboundBuffer.Peek(0)
-			if len(iov) > MaxIovSize {
-				iov = iov[:MaxIovSize]
-			}
-			n, err := io.Writev(c.fd, iov)
-			if err != nil && err != unix.EAGAIN {
-				el.getLogger().Warnf("closeConn: error occurs when sending data back to peer, %v", err)
-				break
-			}
-			_, _ = c.outboundBuffer.Discard(n)
+		if !c.closing {
+			return el.closeAfterFlush(c, err)
+		}
+		return
+	}
+	if c.closing {
+		c.flushTimer.Stop()
+		if err == nil {
+			err = c.closeErr
+		}
+	}
+
+	if sampler, ok := el.eventHandler.(TCPInfoSampler); ok && c.isTCP() {
+		if info, e := c.TCPInfo(); e == nil {
+			sampler.OnCloseTCPInfo(c, info)
 		}
 	}
 
@@ -238,6 +357,109 @@ func (el *eventloop) closeConn(c *conn, err error) (rerr error) {
 	return
 }
 
+// closeAfterFlush puts the connection into the closing state, in which it stops reading from the peer and keeps
+// sending the residual data in the outbound buffer, it's closed once the outbound buffer is drained, or with
+// the leftover data discarded after Options.CloseFlushTimeout.
+func (el *eventloop) closeAfterFlush(c *conn, err error) error {
+	c.closing, c.closeErr = true, err
+	timeout := el.engine.opts.CloseFlushTimeout
+	if timeout <= 0 {
+		timeout = DefaultCloseFlushTimeout
+	}
+	c.flushTimer = time.AfterFunc(timeout, func() {
+		_ = el.poller.Trigger(func(_ interface{}) error {
+			if !c.opened || !c.closing {
+				return nil
+			}
+			el.getLogger().Warnf("closeConn: failed to send %d bytes back to peer on fd=%d within %v",
+				c.outboundBuffer.Buffered(), c.fd, timeout)
+			return el.dropConn(c, nil)
+		}, nil)
+	})
+	if e := el.poller.ModWrite(c.pollAttachment); e != nil {
+		el.getLogger().Warnf("closeConn: failed to watch the writable events on fd=%d, %v", c.fd, e)
+		return el.dropConn(c, nil)
+	}
+	return nil
+}
+
+// flush sends the residual data in the outbound buffer of the closing connection,
+// and closes the connection once the outbound buffer is drained, see closeAfterFlush.
+func (el *eventloop) flush(c *conn) error {
+	var (
+		n   int
+		err error
+	)
+	if len(c.outFds) > 0 {
+		n, err = c.writeWithFds()
+	} else {
+		iov := c.outboundBuffer.Peek(MaxBytesToWritePerLoop)
+		if len(iov) > MaxIovSize {
+			iov = iov[:MaxIovSize]
+		}
+		n, err = io.Writev(c.fd, iov)
+	}
+	_, _ = c.outboundBuffer.Discard(n)
+	switch err {
+	case nil:
+	case unix.EAGAIN:
+		return nil
+	default:
+		el.getLogger().Warnf("closeConn: error occurs when sending data back to peer, %v", err)
+		return el.dropConn(c, nil)
+	}
+	if c.outboundBuffer.IsEmpty() {
+		return el.closeConn(c, nil)
+	}
+	return nil
+}
+
+// dropConn closes the connection right away with the residual data in the outbound buffer discarded.
+func (el *eventloop) dropConn(c *conn, err error) error {
+	_, _ = c.outboundBuffer.Discard(c.outboundBuffer.Buffered())
+	return el.closeConn(c, err)
+}
+
+// abortConn closes the connection with SO_LINGER set to zero, in which case the peer of a TCP connection
+// receives a RST, the pending data in the outbound buffer is discarded instead of being flushed.
+func (el *eventloop) abortConn(c *conn) error {
+	if !c.opened {
+		return nil
+	}
+	if err := unix.SetsockoptLinger(c.fd, unix.SOL_SOCKET, unix.SO_LINGER, &unix.Linger{Onoff: 1}); err != nil {
+		el.getLogger().Warnf("abortConn: failed to set SO_LINGER on fd=%d, %v", c.fd, os.NewSyscallError("setsockopt", err))
+	}
+	return el.dropConn(c, gerrors.ErrConnectionAborted)
+}
+
+// drain stops accepting new connections on the listeners owned by this event-loop.
+func (el *eventloop) drain(_ interface{}) error {
+	// The UDP sessions are unable to send datagrams without the listeners.
+	for _, c := range el.udpSessions {
+		_ = el.closeConn(c, nil)
+	}
+	// The closed listeners are removed from the event-loop for good, since their file descriptors may be reused.
+	for fd, ln := range el.listeners {
+		el.discardUDP(fd)
+		_ = el.poller.Delete(fd)
+		ln.close()
+		delete(el.listeners, fd)
+		delete(el.lnAttachments, fd)
+	}
+	return nil
+}
+
+// notifyShutdown notifies all active connections of this event-loop of the upcoming shutdown.
+func (el *eventloop) notifyShutdown(_ interface{}) error {
+	if notifier, ok := el.eventHandler.(ShutdownNotifier); ok {
+		for _, c := range el.connections {
+			notifier.OnShutdownNotice(c)
+		}
+	}
+
+	return nil
+}
+
 func (el *eventloop) wake(c *conn) error {
 	if co, ok := el.connections[c.fd]; !ok || co != c {
 		return nil // ignore stale wakes.
@@ -297,24 +519,86 @@ func (el *eventloop) handleAction(c *conn, action Action) error {
 	}
 }
 
-func (el *eventloop) readUDP(fd int, _ netpoll.IOEvent) error {
-	n, sa, err := unix.Recvfrom(fd, el.buffer, 0)
+// udpPollAttachment returns the attachment of the UDP listener or socket fd registered on the poller.
+func (el *eventloop) udpPollAttachment(fd int) *netpoll.PollAttachment {
+	if pa, ok := el.lnAttachments[fd]; ok {
+		return pa
+	}
+	if c, ok := el.udpSockets[fd]; ok {
+		return c.pollAttachment
+	}
+	return nil
+}
+
+func (el *eventloop) readUDP(fd int, ev netpoll.IOEvent) error {
+	if el.udpBatch != nil {
+		return el.readUDPBatch(fd, ev)
+	}
+
+	var (
+		n, oobn int
+		sa      unix.Sockaddr
+		err     error
+		syscall = "recvfrom"
+	)
+	ln, ok := el.listeners[fd]
+	if ok && ln.oob != nil {
+		n, oobn, _, sa, err = unix.Recvmsg(fd, el.buffer, ln.oob, 0)
+		syscall = "recvmsg"
+	} else {
+		n, sa, err = unix.Recvfrom(fd, el.buffer, 0)
+	}
 	if err != nil {
 		if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
 			return nil
 		}
 		return fmt.Errorf("failed to read UDP packet from fd=%d in event-loop(%d), %v",
-			fd, el.idx, os.NewSyscallError("recvfrom", err))
+			fd, el.idx, os.NewSyscallError(syscall, err))
 	}
+	var local []byte
+	if oobn > 0 {
+		local = socket.ParsePktInfo(ln.oob[:oobn])
+	}
+	return el.handleDatagram(fd, sa, local, el.buffer[:n], 0)
+}
+
+// handleDatagram invokes OnTraffic for buf which is received on fd from sa at the local IP address local,
+// which is nil if the packet information is unavailable, buf consists of multiple datagrams of segSize bytes
+// if they were coalesced by UDP GRO, otherwise segSize is 0.
+func (el *eventloop) handleDatagram(fd int, sa unix.Sockaddr, local []byte, buf []byte, segSize int) error {
 	var c *conn
-	if fd == el.ln.fd {
-		c = newUDPConn(fd, el, el.ln.addr, sa, false)
-	} else {
-		c = el.udpSockets[fd]
+	if ln, ok := el.listeners[fd]; ok {
+		if key, ok := newUDPSessionKey(fd, sa); ok && el.udpSessions != nil {
+			var err error
+			if c, err = el.udpSession(ln, key, sa, local); c == nil {
+				return err
+			}
+		} else {
+			c = newUDPConn(fd, el, ln.udpLocalAddr(local), sa, false)
+			c.ln = ln
+		}
+	} else if c = el.udpSockets[fd]; c == nil {
+		return nil
 	}
-	c.buffer = el.buffer[:n]
-	action := el.eventHandler.OnTraffic(c)
-	if c.peer != nil {
+
+	session := c.opened && c.peer != nil
+	var action Action
+	for {
+		seg := buf
+		if segSize > 0 && len(seg) > segSize {
+			seg = seg[:segSize]
+		}
+		c.buffer = seg
+		action = el.eventHandler.OnTraffic(c)
+		if buf = buf[len(seg):]; len(buf) == 0 || action == Shutdown || (action == Close && session) {
+			break
+		}
+	}
+
+	switch {
+	case session: // UDP session, it stays alive until being closed or expired
+		return el.handleAction(c, action)
+	case c.peer != nil: // one-off connection of the UDP listener
 		c.releaseUDP()
 	}
 	if action == Shutdown {
@@ -0,0 +1,30 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build freebsd || dragonfly || darwin
+// +build freebsd dragonfly darwin
+
+package gnet
+
+import "golang.org/x/sys/unix"
+
+// recvmsgFlags is empty since MSG_CMSG_CLOEXEC is not available on all of the BSD systems.
+const recvmsgFlags = 0
+
+// closeOnExec marks the received file descriptors close-on-exec right after recvmsg(2) returns.
+func closeOnExec(fds []int) {
+	for _, fd := range fds {
+		unix.CloseOnExec(fd)
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package gnet
+
+import "golang.org/x/sys/unix"
+
+// recvmsgFlags makes the file descriptors received by recvmsg(2) close-on-exec atomically, so that they
+// won't be leaked to the child processes forked by other goroutines, e.g. by Engine.Restart.
+const recvmsgFlags = unix.MSG_CMSG_CLOEXEC
+
+// closeOnExec is a no-op since the received file descriptors are close-on-exec already, see recvmsgFlags.
+func closeOnExec(_ []int) {}
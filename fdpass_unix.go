@@ -0,0 +1,187 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || darwin
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/v2/internal/io"
+	"github.com/panjf2000/gnet/v2/internal/netpoll"
+	gerrors "github.com/panjf2000/gnet/v2/pkg/errors"
+)
+
+// maxFdsPerMsg is the maximum number of file descriptors that can be received in one message,
+// it's SCM_MAX_FD on Linux.
+const maxFdsPerMsg = 253
+
+// pendingFds is a batch of file descriptors waiting in the outbound buffer,
+// they are sent along with the byte at offset at of the outbound buffer.
+type pendingFds struct {
+	at  int
+	fds []int
+}
+
+// isUnixStream reports whether c is a unix or unixpacket connection that is able to pass file descriptors.
+func (c *conn) isUnixStream() bool {
+	_, ok := c.localAddr.(*net.UnixAddr)
+	return ok && !c.isDatagram
+}
+
+func (c *conn) ReceivedFds() (fds []int) {
+	fds, c.inFds = c.inFds, nil
+	return
+}
+
+func (c *conn) WriteWithFds(buf []byte, fds []int) (n int, err error) {
+	if !c.isUnixStream() {
+		return 0, gerrors.ErrUnsupportedOp
+	}
+	if len(fds) == 0 {
+		return c.Write(buf)
+	}
+	if c.closing {
+		return 0, gerrors.ErrConnectionClosed
+	}
+	if c.writeClosed {
+		return 0, gerrors.ErrWriteClosed
+	}
+	if len(buf) == 0 {
+		return 0, gerrors.ErrNoDataForFds
+	}
+
+	if c.outboundBuffer.IsEmpty() {
+		if n, err = unix.SendmsgN(c.fd, buf, unix.UnixRights(fds...), nil, 0); err == nil {
+			// The file descriptors have been sent along with the first byte,
+			// buffer the leftover data for the next round.
+			if n < len(buf) {
+				_, _ = c.outboundBuffer.Write(buf[n:])
+				err = c.watchWrite()
+			}
+			return len(buf), err
+		}
+		if err != unix.EAGAIN {
+			return 0, c.loop.closeConn(c, os.NewSyscallError("sendmsg", err))
+		}
+	}
+
+	// The file descriptors can't be sent right now, duplicate them so that the caller
+	// is free to close its own copies once WriteWithFds returns.
+	dups := make([]int, 0, len(fds))
+	for _, fd := range fds {
+		dupFD, sc, e := netpoll.Dup(fd)
+		if e != nil {
+			closeFds(dups)
+			return 0, os.NewSyscallError(sc, e)
+		}
+		dups = append(dups, dupFD)
+	}
+	empty := c.outboundBuffer.IsEmpty()
+	c.outFds = append(c.outFds, pendingFds{at: c.outboundBuffer.Buffered(), fds: dups})
+	_, _ = c.outboundBuffer.Write(buf)
+	if empty {
+		err = c.watchWrite()
+	}
+	return len(buf), err
+}
+
+// writeWithFds sends the outbound buffer up to the next batch of pending file descriptors,
+// or the batch of pending file descriptors at the head of the outbound buffer with the data
+// that follows them, the caller is responsible for discarding the n bytes from the outbound buffer.
+func (c *conn) writeWithFds() (n int, err error) {
+	if pf := c.outFds[0]; pf.at > 0 {
+		limit := MaxBytesToWritePerLoop
+		if pf.at < limit {
+			limit = pf.at
+		}
+		iov := c.outboundBuffer.Peek(limit)
+		if len(iov) > 1 {
+			if len(iov) > MaxIovSize {
+				iov = iov[:MaxIovSize]
+			}
+			n, err = io.Writev(c.fd, iov)
+		} else {
+			n, err = unix.Write(c.fd, iov[0])
+		}
+	} else {
+		limit := MaxBytesToWritePerLoop
+		if len(c.outFds) > 1 && c.outFds[1].at < limit {
+			limit = c.outFds[1].at
+		}
+		iov := c.outboundBuffer.Peek(limit)
+		if n, err = unix.SendmsgN(c.fd, iov[0], unix.UnixRights(pf.fds...), nil, 0); err == nil {
+			closeFds(pf.fds)
+			c.outFds[0] = pendingFds{}
+			c.outFds = c.outFds[1:]
+		}
+	}
+	if n < 0 {
+		n = 0
+	}
+	for i := range c.outFds {
+		c.outFds[i].at -= n
+	}
+	return
+}
+
+// recvmsg reads data from the unix connection into the buffer of event-loop,
+// collecting the file descriptors sent along with the data.
+func (el *eventloop) recvmsg(c *conn) (n int, err error) {
+	if el.oob == nil {
+		el.oob = make([]byte, unix.CmsgSpace(maxFdsPerMsg*4))
+	}
+	n, oobn, flags, _, err := unix.Recvmsg(c.fd, el.buffer, el.oob, recvmsgFlags)
+	if err != nil || oobn == 0 {
+		return
+	}
+	if flags&unix.MSG_CTRUNC != 0 {
+		el.getLogger().Warnf("recvmsg: control message truncated on fd=%d, some file descriptors are lost", c.fd)
+	}
+	msgs, e := unix.ParseSocketControlMessage(el.oob[:oobn])
+	if e != nil {
+		el.getLogger().Warnf("recvmsg: failed to parse control message on fd=%d, %v", c.fd, e)
+		return
+	}
+	for i := range msgs {
+		fds, e := unix.ParseUnixRights(&msgs[i])
+		if e != nil {
+			continue
+		}
+		closeOnExec(fds)
+		c.inFds = append(c.inFds, fds...)
+	}
+	return
+}
+
+// releaseFds closes the file descriptors that are neither claimed by ReceivedFds nor sent to the peer.
+func (c *conn) releaseFds() {
+	closeFds(c.inFds)
+	c.inFds = nil
+	for _, pf := range c.outFds {
+		closeFds(pf.fds)
+	}
+	c.outFds = nil
+}
+
+func closeFds(fds []int) {
+	for _, fd := range fds {
+		_ = unix.Close(fd)
+	}
+}
@@ -58,11 +58,37 @@ func (s Engine) CountConnections() (count int) {
 	return
 }
 
-// DupFd returns a copy of the underlying file descriptor of listener.
+// CountRejectedConnections returns the number of the connections rejected by the connection limits
+// since the engine started, see Options.MaxConns.
+func (s Engine) CountRejectedConnections() (count int) {
+	if s.eng.admission != nil {
+		count = int(s.eng.admission.countRejected())
+	}
+	return
+}
+
+// PauseAccept stops accepting new connections on the TCP, unix and unixpacket listeners until ResumeAccept
+// is called, the active connections are not affected and the new connections are queued in the backlogs
+// of the listeners in the meantime, which are refused by the operating system once the backlogs are full.
+//
+// PauseAccept returns after all event-loops have stopped accepting, if any of them fails, the others are
+// resumed and the errors are returned. It waits for the event-loops, so it must not be called from the
+// EventHandler methods, which run inside the event-loops.
+func (s Engine) PauseAccept() error {
+	return s.eng.pauseAccept(true)
+}
+
+// ResumeAccept resumes accepting new connections paused by PauseAccept, it waits for the event-loops and
+// restores them on failure just like PauseAccept does.
+func (s Engine) ResumeAccept() error {
+	return s.eng.pauseAccept(false)
+}
+
+// DupFd returns a copy of the underlying file descriptor of the first listener.
 // It is the caller's responsibility to close dupFD when finished.
 // Closing listener does not affect dupFD, and closing dupFD does not affect listener.
 func (s Engine) DupFd() (dupFD int, err error) {
-	dupFD, sc, err := s.eng.ln.dup()
+	dupFD, sc, err := s.eng.listeners[0].dup()
 	if err != nil {
 		logging.Warnf("%s failed when duplicating new fd\n", sc)
 	}
@@ -149,7 +175,9 @@ type Conn interface {
 	// SetContext sets a user-defined context.
 	SetContext(ctx interface{})
 
-	// LocalAddr is the connection's local socket address.
+	// LocalAddr is the connection's local socket address, for the datagrams received on a UDP listener
+	// bound to a wildcard address, it's the local address the datagram was sent to on Linux, which is
+	// also used as the source address of the replies.
 	LocalAddr() (addr net.Addr)
 
 	// RemoteAddr is the connection's remote peer address.
@@ -173,6 +201,187 @@ type Conn interface {
 	Close() (err error)
 }
 
+// ListenerIDConn is an optional interface implemented by the connections of gnet, which can be obtained by
+// type-asserting Conn, it's used for telling which listener accepted the connection of an engine started by RunMulti.
+type ListenerIDConn interface {
+	// ListenerID returns the index of the protocol address passed to RunMulti whose listener accepted
+	// the connection, it's always 0 for the engines started by Run or RunWithListener, and -1 for the connections
+	// that are not accepted by the engine, like the ones dialed by Client or enrolled by Engine.Enroll.
+	ListenerID() (id int)
+}
+
+// FdPassingConn is an optional interface implemented by the connections of gnet, which can be obtained by
+// type-asserting Conn, it's used for passing file descriptors over unix and unixpacket connections.
+type FdPassingConn interface {
+	// ReceivedFds returns the file descriptors passed by the peer over a unix or unixpacket connection
+	// along with the inbound data, the caller takes the ownership of them and is responsible for closing them.
+	// The file descriptors that are not claimed are closed once all the inbound data has been consumed
+	// after OnTraffic, or when the connection is closed.
+	ReceivedFds() (fds []int)
+
+	// WriteWithFds writes one byte slice to peer synchronously along with the file descriptors attached
+	// to its first byte, it's only available on unix and unixpacket connections and buf must not be empty.
+	// The caller retains the ownership of fds, which are duplicated if they can't be sent right away.
+	WriteWithFds(buf []byte, fds []int) (n int, err error)
+}
+
+// PeerCredentialsConn is an optional interface implemented by the connections of gnet, which can be obtained by
+// type-asserting Conn, it's used for identifying the peer process of a unix or unixpacket connection.
+type PeerCredentialsConn interface {
+	// PeerCredentials returns the credentials of the process on the other end of a unix or unixpacket connection,
+	// which are captured when the peer connects, it's only available on Linux.
+	PeerCredentials() (cred *Credentials, err error)
+}
+
+// Credentials is the identity of the process on the other end of a unix connection.
+type Credentials struct {
+	// PID is the process ID of the peer.
+	PID int32
+
+	// UID is the effective user ID of the peer.
+	UID uint32
+
+	// GID is the effective group ID of the peer.
+	GID uint32
+}
+
+// AbortableConn is an optional interface implemented by the connections of gnet, which can be obtained by
+// type-asserting Conn, it's used for closing connections with a reason or abortively, its methods are concurrency-safe.
+type AbortableConn interface {
+	// CloseWithError closes the current connection like Close, except that reason is passed to OnClose,
+	// which tells the connections closed by the application apart from the others.
+	CloseWithError(reason error) (err error)
+
+	// Abort closes the current connection abortively, the data in the outbound buffer is discarded and the peer of
+	// a TCP connection receives a RST instead of a FIN since SO_LINGER is set to zero, OnClose is fired with
+	// ErrConnectionAborted. It's meant for dropping the misbehaving peers instantly.
+	Abort() (err error)
+}
+
+// HalfCloseConn is an optional interface implemented by the connections of gnet, which can be obtained by
+// type-asserting Conn, it's used for shutting down the writing side of a TCP or unix connection.
+type HalfCloseConn interface {
+	// CloseWrite shuts down the writing side of the connection after the data in the outbound buffer is flushed,
+	// the subsequent writes fail with ErrWriteClosed. The connection is closed once the peer has shut down its
+	// writing side as well, see Options.HalfClose. It's concurrency-safe.
+	CloseWrite() (err error)
+}
+
+// TCPOptionsConn is an optional interface implemented by the connections of gnet, which can be obtained by
+// type-asserting Conn, it's used for overriding the TCP socket options set up by Options on a connection,
+// its methods return ErrUnsupportedOp on the connections that are not TCP.
+type TCPOptionsConn interface {
+	// SetKeepAlive enables the keep-alive of a TCP connection, idle is the duration the connection needs to remain
+	// idle before the keep-alive probes are sent, which is also the interval between the probes if interval is zero,
+	// count is the number of the unacknowledged probes before the connection is dropped, the system default is
+	// used if count is zero, see Options.TCPKeepAlive.
+	SetKeepAlive(idle, interval time.Duration, count int) (err error)
+
+	// SetUserTimeout sets up TCP_USER_TIMEOUT of a TCP connection, see Options.TCPUserTimeout.
+	SetUserTimeout(timeout time.Duration) (err error)
+
+	// SetLinger sets the behavior of Close on a TCP connection which still has data waiting to be sent or
+	// to be acknowledged, linger is interpreted the same way as Options.SocketLinger, except that a zero
+	// linger turns SO_LINGER off to restore the system default.
+	SetLinger(linger time.Duration) (err error)
+
+	// SetTOS sets up the IP_TOS/IPV6_TCLASS of a TCP connection, see Options.SocketTOS.
+	SetTOS(tos int) (err error)
+
+	// SetQuickAck enables/disables TCP_QUICKACK of a TCP connection, see Options.TCPQuickAck.
+	SetQuickAck(quickAck bool) (err error)
+
+	// SetNotSentLowat sets up TCP_NOTSENT_LOWAT of a TCP connection, see Options.TCPNotSentLowat.
+	SetNotSentLowat(bytes int) (err error)
+
+	// SetCongestion sets up the congestion control algorithm of a TCP connection, see Options.TCPCongestion.
+	SetCongestion(name string) (err error)
+
+	// SetMark sets up SO_MARK of a TCP connection, see Options.SocketMark.
+	SetMark(mark int) (err error)
+}
+
+// ControlConn is an optional interface implemented by the connections of gnet, which can be obtained by
+// type-asserting Conn, it's used for accessing the underlying socket of a connection.
+type ControlConn interface {
+	// Control invokes f on the underlying socket of the connection like syscall.RawConn.Control, which is meant
+	// for manipulating the socket options at runtime, note that the socket is shared by all the UDP connections
+	// of a listener. The socket must not be closed or retained after f returns.
+	Control(f func(fd int) error) (err error)
+}
+
+// TCPInfoConn is an optional interface implemented by the connections of gnet, which can be obtained by
+// type-asserting Conn, it's used for inspecting the state of a TCP connection.
+type TCPInfoConn interface {
+	// TCPInfo returns the statistics of a TCP connection retrieved from the kernel with TCP_INFO,
+	// it's only available on Linux.
+	TCPInfo() (info *TCPInfo, err error)
+}
+
+// TCPInfo is the statistics of a TCP connection, see TCPInfoConn.TCPInfo, the fields that are not
+// provided by the running kernel are left zero.
+type TCPInfo struct {
+	// State is the state of the connection, such as TCP_ESTABLISHED.
+	State uint8
+
+	// RTT is the smoothed round-trip time.
+	RTT time.Duration
+
+	// RTTVar is the variance of the round-trip time.
+	RTTVar time.Duration
+
+	// MinRTT is the minimum round-trip time observed.
+	MinRTT time.Duration
+
+	// RTO is the retransmission timeout.
+	RTO time.Duration
+
+	// Retransmits is the number of consecutive retransmission timeouts of the unacknowledged data.
+	Retransmits uint32
+
+	// TotalRetrans is the total number of the retransmitted segments.
+	TotalRetrans uint32
+
+	// Lost is the number of the segments considered lost.
+	Lost uint32
+
+	// Unacked is the number of the segments sent but not acknowledged yet.
+	Unacked uint32
+
+	// SndCwnd is the congestion window in segments.
+	SndCwnd uint32
+
+	// SndSsthresh is the slow start threshold in segments.
+	SndSsthresh uint32
+
+	// SndMSS is the maximum segment size for sending.
+	SndMSS uint32
+
+	// RcvMSS is the maximum segment size for receiving.
+	RcvMSS uint32
+
+	// NotSentBytes is the number of the bytes in the send buffer that are not sent yet.
+	NotSentBytes uint32
+
+	// BytesSent is the number of the bytes sent, including the retransmitted ones.
+	BytesSent uint64
+
+	// BytesAcked is the number of the bytes acknowledged by the peer.
+	BytesAcked uint64
+
+	// BytesReceived is the number of the bytes received from the peer.
+	BytesReceived uint64
+
+	// BytesRetrans is the number of the bytes retransmitted.
+	BytesRetrans uint64
+
+	// DeliveryRate is the most recent goodput in bytes per second.
+	DeliveryRate uint64
+
+	// PacingRate is the pacing rate in bytes per second.
+	PacingRate uint64
+}
+
 type (
 	// EventHandler represents the engine events' callbacks for the Run call.
 	// Each event has an Action return value that is used manage the state
@@ -211,6 +420,46 @@ type (
 		OnTick() (delay time.Duration, action Action)
 	}
 
+	// ShutdownNotifier is an optional interface that can be implemented by EventHandler,
+	// it's used for being notified when the engine starts draining connections during a graceful shutdown,
+	// see Options.GracefulShutdown for details.
+	ShutdownNotifier interface {
+		// OnShutdownNotice fires for each active connection after the engine stops accepting new connections
+		// in a graceful shutdown, it gives protocols a chance to inform the peer (GOAWAY-style messages, etc.)
+		// that the connection is about to be closed, it's also fired right after OnOpen for the connections
+		// that are opened while the engine is draining.
+		OnShutdownNotice(c Conn)
+	}
+
+	// ReadEOFNotifier is an optional interface that can be implemented by EventHandler,
+	// it's used for being notified when the peer shuts down the writing side of a connection,
+	// see Options.HalfClose for details.
+	ReadEOFNotifier interface {
+		// OnReadEOF fires when the peer has shut down its writing side of the connection, after all the data
+		// sent by the peer has been passed to OnTraffic, the connection remains writable until it's closed
+		// or its writing side is shut down by HalfCloseConn.CloseWrite.
+		OnReadEOF(c Conn) (action Action)
+	}
+
+	// AcceptFilter is an optional interface that can be implemented by EventHandler,
+	// it's used for rejecting the connections right after they're accepted by the TCP, unix and unixpacket listeners,
+	// before any other work is done for them, see CIDRFilter for a built-in implementation.
+	AcceptFilter interface {
+		// OnAccept fires when a new connection from remote is accepted by the listener bound to local,
+		// the connection is closed right away unless it returns None, in which case neither OnOpen nor
+		// OnClose fires for it. Note that OnAccept may be called by multiple event-loops concurrently.
+		OnAccept(remote, local net.Addr) (action Action)
+	}
+
+	// TCPInfoSampler is an optional interface that can be implemented by EventHandler,
+	// it's used for sampling the statistics of the TCP connections when they're closed, e.g. to record them
+	// into the metrics, see TCPInfoConn.TCPInfo. It's only called on Linux where TCP_INFO is available.
+	TCPInfoSampler interface {
+		// OnCloseTCPInfo fires with the last statistics of a TCP connection right before its socket is closed,
+		// ahead of OnClose, it's skipped if the statistics can't be retrieved.
+		OnCloseTCPInfo(c Conn, info *TCPInfo)
+	}
+
 	// BuiltinEventEngine is a built-in implementation of EventHandler which sets up each method with a default implementation,
 	// you can compose it with your own implementation of EventHandler when you don't want to implement all methods
 	// in EventHandler.
@@ -259,16 +508,81 @@ var MaxStreamBufferCap = 64 * 1024 // 64KB
 // Address should use a scheme prefix and be formatted
 // like `tcp://192.168.0.10:9851` or `unix://socket`.
 // Valid network schemes:
-//  tcp   - bind to both IPv4 and IPv6
-//  tcp4  - IPv4
-//  tcp6  - IPv6
-//  udp   - bind to both IPv4 and IPv6
-//  udp4  - IPv4
-//  udp6  - IPv6
-//  unix  - Unix Domain Socket
+//  tcp        - bind to both IPv4 and IPv6
+//  tcp4       - IPv4
+//  tcp6       - IPv6
+//  udp        - bind to both IPv4 and IPv6
+//  udp4       - IPv4
+//  udp6       - IPv6
+//  unix       - Unix Domain Socket, like `unix://path` or `unix://@name` in the abstract namespace on Linux
+//  unixgram   - Unix Domain Socket of datagrams
+//  unixpacket - Unix Domain Socket of sequenced packets
+//  fd         - pre-opened socket passed by the service manager, like `fd://3` or `fd://name`
 //
 // The "tcp" network scheme is assumed when one is not specified.
+//
+// The "unixgram" sockets are handled like UDP, and the "unixpacket" sockets are handled like TCP except that
+// every read returns one message, a message longer than ReadBufferCap is truncated, and the messages are sent
+// as they're written unless they're buffered due to a full socket send buffer, in which case the buffered
+// messages may be merged.
+//
+// The "fd" network scheme follows the socket activation protocol of systemd: the listening sockets
+// are passed on from file descriptor 3 with the environment variables LISTEN_PID, LISTEN_FDS and
+// LISTEN_FDNAMES, a socket can be referred to either by its file descriptor number or by its name,
+// and the network of the socket is determined by probing it. Only the file descriptors passed to
+// the current process are accepted, each of them can be used by one listener, and the environment
+// variables are cleared once read so that they won't be inherited by the child processes.
 func Run(eventHandler EventHandler, protoAddr string, opts ...Option) (err error) {
+	return RunMulti(eventHandler, []string{protoAddr}, opts...)
+}
+
+// RunMulti is like Run but starts handling events on multiple addresses, e.g. an IPv4 and an IPv6 address,
+// or a TCP port plus a unix socket, all listeners share the same event-loops and event handler.
+//
+// ListenerIDConn.ListenerID tells which of protoAddrs the connection was accepted on, and the engine can be
+// stopped by calling Stop with any of protoAddrs.
+//
+// The UDP listeners are served by the sub event-loops directly unless Options.ReusePort is set or
+// all protoAddrs are UDP addresses, in which case every event-loop serves its own copy of each listener.
+func RunMulti(eventHandler EventHandler, protoAddrs []string, opts ...Option) error {
+	if len(protoAddrs) == 0 {
+		return errors.ErrEmptyProtoAddrs
+	}
+
+	return run(eventHandler, protoAddrs, func(options *Options) (listeners []*listener, err error) {
+		for i, protoAddr := range protoAddrs {
+			network, addr := parseProtoAddr(protoAddr)
+			var ln *listener
+			if ln, err = initListener(network, addr, options); err != nil {
+				return
+			}
+			ln.id = i
+			listeners = append(listeners, ln)
+		}
+		return
+	}, opts...)
+}
+
+// RunWithListener is like Run but starts handling events on ln which is created by the standard library
+// or other libraries, ln must be a *net.TCPListener or *net.UnixListener, or implement syscall.Conn.
+//
+// The socket underlying ln is duplicated and ln itself is closed once the engine takes it over,
+// a unix socket file is removed when the engine shuts down, just like the ones created by Run.
+// The engine can be stopped by calling Stop with ln.Addr().Network() + "://" + ln.Addr().String().
+func RunWithListener(eventHandler EventHandler, ln net.Listener, opts ...Option) error {
+	protoAddr := ln.Addr().Network() + "://" + ln.Addr().String()
+	return run(eventHandler, []string{protoAddr}, func(_ *Options) ([]*listener, error) {
+		l, err := adoptListener(ln)
+		if err != nil {
+			return nil, err
+		}
+		return []*listener{l}, nil
+	}, opts...)
+}
+
+// run sets up the options and starts the engine with the listeners made up by initListeners.
+func run(eventHandler EventHandler, protoAddrs []string,
+	initListeners func(*Options) ([]*listener, error), opts ...Option) (err error) {
 	options := loadOptions(opts...)
 
 	logging.Debugf("default logging level is %s", logging.LogLevel())
@@ -311,15 +625,17 @@ func Run(eventHandler EventHandler, protoAddr string, opts ...Option) (err error
 		options.ReadBufferCap = toolkit.CeilToPowerOfTwo(rbc)
 	}
 
-	network, addr := parseProtoAddr(protoAddr)
-
-	var ln *listener
-	if ln, err = initListener(network, addr, options); err != nil {
+	listeners, err := initListeners(options)
+	defer func() {
+		for _, ln := range listeners {
+			ln.close()
+		}
+	}()
+	if err != nil {
 		return
 	}
-	defer ln.close()
 
-	return serve(eventHandler, ln, options, protoAddr)
+	return serve(eventHandler, listeners, options, protoAddrs)
 }
 
 var (
@@ -331,10 +647,17 @@ var (
 
 // Stop gracefully shuts down the engine without interrupting any active event-loops,
 // it waits indefinitely for connections and event-loops to be closed and then shuts down.
+//
+// If the engine is running with Options.GracefulShutdown, Stop closes the listener first and
+// waits for all active connections to be closed on their own, the remaining connections are
+// closed forcibly when ctx is done, in which case Stop returns ctx.Err().
 func Stop(ctx context.Context, protoAddr string) error {
 	var eng *engine
 	if s, ok := allEngines.Load(protoAddr); ok {
 		eng = s.(*engine)
+		if eng.opts.GracefulShutdown && !eng.isInShutdown() {
+			eng.drain(ctx)
+		}
 		eng.signalShutdown()
 		defer allEngines.Delete(protoAddr)
 	} else {
@@ -345,26 +668,17 @@ func Stop(ctx context.Context, protoAddr string) error {
 		return errors.ErrEngineInShutdown
 	}
 
-	ticker := time.NewTicker(shutdownPollInterval)
-	defer ticker.Stop()
-	for {
-		if eng.isInShutdown() {
-			return nil
-		}
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-ticker.C:
-		}
-	}
+	return eng.awaitShutdown(ctx)
 }
 
 func parseProtoAddr(addr string) (network, address string) {
 	network = "tcp"
-	address = strings.ToLower(addr)
+	address = addr
 	if strings.Contains(address, "://") {
-		pair := strings.Split(address, "://")
-		network = pair[0]
+		pair := strings.SplitN(address, "://", 2)
+		// Only the network scheme is case-insensitive, the names of
+		// unix sockets and activated sockets are case-sensitive.
+		network = strings.ToLower(pair[0])
 		address = pair[1]
 	}
 	return
@@ -21,17 +21,25 @@ import (
 	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"math/rand"
 	"net"
+	"os"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
 
 	gerr "github.com/panjf2000/gnet/v2/pkg/errors"
 	"github.com/panjf2000/gnet/v2/pkg/logging"
@@ -913,6 +921,1665 @@ func testStop(t *testing.T, network, addr string) {
 	assert.NoError(t, err)
 }
 
+func TestGracefulShutdown(t *testing.T) {
+	testGracefulShutdown(t, "tcp", ":9981")
+}
+
+type testGracefulShutdownServer struct {
+	*BuiltinEventEngine
+	tester                   *testing.T
+	eng                      Engine
+	network, addr, protoAddr string
+	action                   bool
+	noticed                  int32
+	stopped                  chan error
+}
+
+func (t *testGracefulShutdownServer) OnBoot(eng Engine) (action Action) {
+	t.eng = eng
+	return
+}
+
+func (t *testGracefulShutdownServer) OnShutdownNotice(c Conn) {
+	atomic.AddInt32(&t.noticed, 1)
+	_, _ = c.Write([]byte("GOAWAY"))
+}
+
+func (t *testGracefulShutdownServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	_, _ = c.Write(buf)
+	return
+}
+
+func (t *testGracefulShutdownServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if !t.action {
+		t.action = true
+		go func() {
+			conn, err := net.Dial(t.network, t.addr)
+			require.NoError(t.tester, err)
+			defer conn.Close()
+			requireEcho(t.tester, conn, "Hello World!")
+
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				t.stopped <- Stop(ctx, t.protoAddr)
+			}()
+
+			// The connection ought to stay alive until the client closes it.
+			goaway := make([]byte, 6)
+			_, err = io.ReadFull(conn, goaway)
+			require.NoError(t.tester, err)
+			require.EqualValues(t.tester, "GOAWAY", goaway)
+			// The listener is closed for good once the engine starts draining.
+			_, err = t.eng.DupFd()
+			require.ErrorIs(t.tester, err, gerr.ErrListenerClosed)
+			require.ErrorIs(t.tester, t.eng.JoinGroup(0, nil, net.IPv4(224, 0, 0, 251)), gerr.ErrEngineInShutdown)
+			requireEcho(t.tester, conn, "Hello World!")
+		}()
+	}
+	return
+}
+
+func testGracefulShutdown(t *testing.T, network, addr string) {
+	events := &testGracefulShutdownServer{
+		tester:    t,
+		network:   network,
+		addr:      addr,
+		protoAddr: network + "://" + addr,
+		stopped:   make(chan error, 1),
+	}
+	err := Run(events, events.protoAddr, WithTicker(true), WithGracefulShutdown(true), WithReuseAddr(true))
+	assert.NoError(t, err)
+	assert.NoError(t, <-events.stopped, "connections are not drained before the deadline")
+	assert.EqualValues(t, 1, atomic.LoadInt32(&events.noticed))
+}
+
+// requireEcho writes data to conn and checks that it's echoed back.
+func requireEcho(t *testing.T, conn net.Conn, data string) {
+	_, err := conn.Write([]byte(data))
+	require.NoError(t, err)
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.EqualValues(t, data, buf)
+}
+
+// testDrivenServer is the scaffolding of the tests which drive the engine with a client: it echoes
+// the inbound data back, runs the client in a goroutine on the first tick and shuts the engine down
+// once the client returns. The tests embed it and override the event handlers they're testing.
+type testDrivenServer struct {
+	*BuiltinEventEngine
+	tester *testing.T
+	eng    Engine
+	client func()
+	action bool
+	done   int32
+}
+
+func newTestDrivenServer(t *testing.T, client func()) *testDrivenServer {
+	return &testDrivenServer{tester: t, client: client}
+}
+
+// echoClient returns a client which dials addr and checks that a message is echoed back.
+func echoClient(t *testing.T, network, addr string) func() {
+	return func() {
+		conn, err := net.Dial(network, addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		requireEcho(t, conn, "Hello World!")
+	}
+}
+
+func (t *testDrivenServer) OnBoot(eng Engine) (action Action) {
+	t.eng = eng
+	return
+}
+
+func (t *testDrivenServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	_, _ = c.Write(buf)
+	return
+}
+
+func (t *testDrivenServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Millisecond * 100
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+		return
+	}
+	if !t.action {
+		t.action = true
+		go func() {
+			defer atomic.StoreInt32(&t.done, 1)
+			t.client()
+		}()
+	}
+	return
+}
+
+// run runs the engine with events, which embeds t, on protoAddr and checks that the client has finished.
+func (t *testDrivenServer) run(events EventHandler, protoAddr string, opts ...Option) {
+	opts = append([]Option{WithTicker(true)}, opts...)
+	err := Run(events, protoAddr, append(opts, WithReuseAddr(true))...)
+	assert.NoError(t.tester, err)
+	assert.EqualValues(t.tester, 1, atomic.LoadInt32(&t.done))
+}
+
+// runMulti is like run, but serves all of protoAddrs.
+func (t *testDrivenServer) runMulti(events EventHandler, protoAddrs []string, opts ...Option) {
+	opts = append([]Option{WithTicker(true)}, opts...)
+	err := RunMulti(events, protoAddrs, append(opts, WithReuseAddr(true))...)
+	assert.NoError(t.tester, err)
+	assert.EqualValues(t.tester, 1, atomic.LoadInt32(&t.done))
+}
+
+func TestInheritedListener(t *testing.T) {
+	testInheritedListener(t, "tcp", ":9982")
+}
+
+func testInheritedListener(t *testing.T, network, addr string) {
+	// The listening socket stays open in the standard library, so binding the address again would fail
+	// unless the engine adopts the inherited file descriptor.
+	ln, err := net.Listen(network, addr)
+	require.NoError(t, err)
+	defer ln.Close()
+	rc, err := ln.(*net.TCPListener).SyscallConn()
+	require.NoError(t, err)
+	var fd, otherFd int
+	require.NoError(t, rc.Control(func(sfd uintptr) { fd, err = unix.Dup(int(sfd)) }))
+	require.NoError(t, err)
+	require.NoError(t, rc.Control(func(sfd uintptr) { otherFd, err = unix.Dup(int(sfd)) }))
+	require.NoError(t, err)
+	// The inherited listeners of the other addresses are left for the other engines.
+	require.NoError(t, os.Setenv(InheritedFdsEnv,
+		fmt.Sprintf("%s://%s=%d;%s://127.0.0.1%s=%d", network, addr, fd, network, addr, otherFd)))
+	// The write end of the pipe is taken over by the engine.
+	p := make([]int, 2)
+	require.NoError(t, unix.Pipe(p))
+	ready := os.NewFile(uintptr(p[0]), "ready")
+	defer ready.Close()
+	require.NoError(t, os.Setenv(restartReadyFdEnv, strconv.Itoa(p[1])))
+
+	ts := newTestDrivenServer(t, echoClient(t, network, addr))
+	ts.run(ts, network+"://"+addr)
+	_, ok := os.LookupEnv(InheritedFdsEnv)
+	assert.False(t, ok, "environment variable of inherited listeners is not cleared")
+
+	// The readiness is reported once the engine has started with the inherited listener.
+	b := make([]byte, 1)
+	_, err = ready.Read(b)
+	assert.NoError(t, err)
+	fd, ok = takeInheritedFd(network, "127.0.0.1"+addr)
+	assert.True(t, ok)
+	assert.EqualValues(t, otherFd, fd)
+	assert.NoError(t, unix.Close(otherFd))
+}
+
+func TestAwaitRestartReady(t *testing.T) {
+	ready, readyW, err := os.Pipe()
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, awaitRestartReady(ctx, ready), context.DeadlineExceeded)
+	_ = ready.Close()
+	_ = readyW.Close()
+
+	// The child process exits without reporting the readiness.
+	ready, readyW, err = os.Pipe()
+	require.NoError(t, err)
+	_ = readyW.Close()
+	assert.ErrorIs(t, awaitRestartReady(context.Background(), ready), gerr.ErrRestartNotReady)
+	_ = ready.Close()
+
+	ready, readyW, err = os.Pipe()
+	require.NoError(t, err)
+	_, _ = readyW.Write([]byte{1})
+	assert.NoError(t, awaitRestartReady(context.Background(), ready))
+	_ = ready.Close()
+	_ = readyW.Close()
+}
+
+func TestSocketActivation(t *testing.T) {
+	t.Run("tcp", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:9984")
+		require.NoError(t, err)
+		defer ln.Close()
+		rc, err := ln.(*net.TCPListener).SyscallConn()
+		require.NoError(t, err)
+		testSocketActivation(t, "tcp", ln.Addr().String(), rc)
+	})
+	t.Run("udp", func(t *testing.T) {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:9984")
+		require.NoError(t, err)
+		defer pc.Close()
+		rc, err := pc.(*net.UDPConn).SyscallConn()
+		require.NoError(t, err)
+		testSocketActivation(t, "udp", pc.LocalAddr().String(), rc)
+	})
+	t.Run("names", func(t *testing.T) {
+		defer func() {
+			_ = os.Unsetenv("LISTEN_PID")
+			_ = os.Unsetenv("LISTEN_FDS")
+			_ = os.Unsetenv("LISTEN_FDNAMES")
+		}()
+		require.NoError(t, os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid())))
+		require.NoError(t, os.Setenv("LISTEN_FDS", "3"))
+		require.NoError(t, os.Setenv("LISTEN_FDNAMES", "web:dns:web"))
+		fd, err := activatedFd("dns")
+		require.NoError(t, err)
+		require.EqualValues(t, 4, fd)
+		fd, err = activatedFd("web")
+		require.NoError(t, err)
+		require.EqualValues(t, 3, fd)
+		fd, err = activatedFd("web")
+		require.NoError(t, err)
+		require.EqualValues(t, 5, fd)
+		_, err = activatedFd("web")
+		require.ErrorIs(t, err, gerr.ErrActivatedListenerNotFound)
+		_, err = activatedFd("ssh")
+		require.ErrorIs(t, err, gerr.ErrActivatedListenerNotFound)
+
+		_, err = activatedFd("3")
+		require.ErrorIs(t, err, gerr.ErrActivatedListenerNotFound, "file descriptor claimed by name")
+		_, err = activatedFd("6")
+		require.ErrorIs(t, err, gerr.ErrActivatedListenerNotFound, "file descriptor not passed by the service manager")
+		_, ok := os.LookupEnv("LISTEN_FDS")
+		require.False(t, ok, "environment variables are cleared once read")
+
+		require.NoError(t, os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid())))
+		require.NoError(t, os.Setenv("LISTEN_FDS", "2"))
+		fd, err = activatedFd("4")
+		require.NoError(t, err)
+		require.EqualValues(t, 4, fd)
+		_, err = activatedFd("4")
+		require.ErrorIs(t, err, gerr.ErrActivatedListenerNotFound, "file descriptor claimed twice")
+
+		require.NoError(t, os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1)))
+		require.NoError(t, os.Setenv("LISTEN_FDS", "3"))
+		require.NoError(t, os.Setenv("LISTEN_FDNAMES", "web:dns:web"))
+		_, err = activatedFd("dns")
+		require.ErrorIs(t, err, gerr.ErrActivatedListenerNotFound, "socket activation for another process")
+		_, err = activatedFd("3")
+		require.ErrorIs(t, err, gerr.ErrActivatedListenerNotFound, "socket activation for another process")
+	})
+}
+
+func testSocketActivation(t *testing.T, network, addr string, rc syscall.RawConn) {
+	var (
+		fd  int
+		err error
+	)
+	require.NoError(t, rc.Control(func(sfd uintptr) { fd, err = unix.Dup(int(sfd)) }))
+	require.NoError(t, err)
+	require.NoError(t, os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid())))
+	require.NoError(t, os.Setenv("LISTEN_FDS", strconv.Itoa(fd-listenFdsStart+1)))
+
+	ts := newTestDrivenServer(t, echoClient(t, network, addr))
+	ts.run(ts, "fd://"+strconv.Itoa(fd), WithMulticore(true))
+}
+
+func TestRunMulti(t *testing.T) {
+	t.Run("reactor", func(t *testing.T) {
+		testRunMulti(t, false)
+	})
+	t.Run("reuseport", func(t *testing.T) {
+		testRunMulti(t, true)
+	})
+}
+
+type testRunMultiServer struct {
+	*testDrivenServer
+	protoAddrs []string
+}
+
+func (t *testRunMultiServer) OnTraffic(c Conn) (action Action) {
+	network, addr := parseProtoAddr(t.protoAddrs[c.(ListenerIDConn).ListenerID()])
+	require.True(t.tester, strings.HasPrefix(network, c.LocalAddr().Network()))
+	if network == "unix" {
+		require.Equal(t.tester, addr, c.LocalAddr().String())
+	}
+	buf, _ := c.Next(-1)
+	_, _ = c.Write(append([]byte(strconv.Itoa(c.(ListenerIDConn).ListenerID())+":"), buf...))
+	return
+}
+
+func (t *testRunMultiServer) runClient() {
+	for i, protoAddr := range t.protoAddrs {
+		network, addr := parseProtoAddr(protoAddr)
+		conn, err := net.Dial(network, addr)
+		require.NoError(t.tester, err)
+		data := []byte("Hello World!")
+		_, _ = conn.Write(data)
+		expected := append([]byte(strconv.Itoa(i)+":"), data...)
+		rsp := make([]byte, len(expected))
+		_, err = io.ReadFull(conn, rsp)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, expected, rsp)
+		_ = conn.Close()
+	}
+}
+
+func testRunMulti(t *testing.T, reuseport bool) {
+	protoAddrs := []string{"tcp://127.0.0.1:9985", "udp://127.0.0.1:9985", "unix://gnet_multi.sock", "tcp6://[::1]:9985"}
+	ts := &testRunMultiServer{protoAddrs: protoAddrs}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.runMulti(ts, protoAddrs, WithMulticore(true), WithReusePort(reuseport))
+
+	err := RunMulti(ts, nil)
+	assert.ErrorIs(t, err, gerr.ErrEmptyProtoAddrs)
+}
+
+func TestRunWithListener(t *testing.T) {
+	t.Run("tcp", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:9986")
+		require.NoError(t, err)
+		testRunWithListener(t, ln)
+	})
+	t.Run("unix", func(t *testing.T) {
+		ln, err := net.Listen("unix", "gnet_adopted.sock")
+		require.NoError(t, err)
+		testRunWithListener(t, ln)
+		_, err = os.Stat("gnet_adopted.sock")
+		require.True(t, os.IsNotExist(err), "socket file should be removed after the engine shuts down")
+	})
+}
+
+type testRunWithListenerServer struct {
+	*testDrivenServer
+	network  string
+	addr     string
+	opened   int32
+	enrolled int32
+	peer     chan string // local address of the client whose server side is enrolled
+}
+
+func (t *testRunWithListenerServer) OnOpen(c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&t.opened, 1)
+	if c.(ListenerIDConn).ListenerID() < 0 {
+		atomic.AddInt32(&t.enrolled, 1)
+		require.Equal(t.tester, <-t.peer, c.RemoteAddr().String())
+	}
+	return
+}
+
+func (t *testRunWithListenerServer) runClient() {
+	echoClient(t.tester, t.network, t.addr)()
+
+	// Enroll the server side of a connection accepted by the standard library.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t.tester, err)
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t.tester, err)
+	defer conn.Close()
+	t.peer <- conn.LocalAddr().String()
+	sc, err := ln.Accept()
+	require.NoError(t.tester, err)
+	_, err = t.eng.Enroll(sc)
+	require.NoError(t.tester, err)
+	requireEcho(t.tester, conn, "Hello World!")
+}
+
+func testRunWithListener(t *testing.T, ln net.Listener) {
+	ts := &testRunWithListenerServer{
+		network: ln.Addr().Network(),
+		addr:    ln.Addr().String(),
+		peer:    make(chan string, 1),
+	}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	err := RunWithListener(ts, ln, WithTicker(true), WithMulticore(true))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&ts.done))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&ts.opened))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&ts.enrolled))
+
+	_, err = ln.Accept()
+	assert.Error(t, err, "the adopted listener should be closed")
+}
+
+func TestUDPBatch(t *testing.T) {
+	t.Run("udp", func(t *testing.T) {
+		testUDPBatch(t, "udp", ":9987", false)
+	})
+	t.Run("udp-writev", func(t *testing.T) {
+		testUDPBatch(t, "udp", ":9988", true)
+	})
+	t.Run("udp-offload", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("UDP GSO/GRO is only available on Linux")
+		}
+		testUDPBatch(t, "udp", ":9989", false, WithUDPGSO(true), WithUDPGRO(true), WithReadBufferCap(64*1024))
+	})
+}
+
+type testUDPBatchServer struct {
+	*testDrivenServer
+	network  string
+	addr     string
+	writev   bool
+	gso      bool
+	packets  int
+	received int32
+}
+
+// sendGSO sends the datagrams of the same size in one go with UDP GSO.
+func (t *testUDPBatchServer) sendGSO(conn *net.UDPConn, datagrams [][]byte) error {
+	oob := make([]byte, unix.CmsgSpace(2))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.IPPROTO_UDP
+	h.Type = 103 // UDP_SEGMENT
+	h.SetLen(unix.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&oob[unix.CmsgLen(0)])) = uint16(len(datagrams[0]))
+	_, _, err := conn.WriteMsgUDP(bytes.Join(datagrams, nil), oob, nil)
+	return err
+}
+
+func (t *testUDPBatchServer) OnTraffic(c Conn) (action Action) {
+	atomic.AddInt32(&t.received, 1)
+	buf, _ := c.Next(-1)
+	if t.writev {
+		_, _ = c.Writev([][]byte{buf[:1], buf[1:]})
+	} else {
+		_, _ = c.Write(buf)
+	}
+	return
+}
+
+func (t *testUDPBatchServer) runClient() {
+	conn, err := net.Dial(t.network, t.addr)
+	require.NoError(t.tester, err)
+	defer conn.Close()
+	expected := make(map[string]struct{}, t.packets)
+	var datagrams [][]byte
+	for i := 0; i < t.packets; i++ {
+		data := []byte(fmt.Sprintf("datagram-%06d", i))
+		expected[string(data)] = struct{}{}
+		if datagrams = append(datagrams, data); len(datagrams) < 8 && i < t.packets-1 {
+			continue
+		}
+		if !t.gso || t.sendGSO(conn.(*net.UDPConn), datagrams) != nil {
+			for _, d := range datagrams {
+				_, err = conn.Write(d)
+				require.NoError(t.tester, err)
+			}
+		}
+		datagrams = datagrams[:0]
+	}
+	buf := make([]byte, 64)
+	for len(expected) > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		require.NoError(t.tester, err)
+		_, ok := expected[string(buf[:n])]
+		require.True(t.tester, ok, "unexpected datagram: %s", buf[:n])
+		delete(expected, string(buf[:n]))
+	}
+}
+
+func testUDPBatch(t *testing.T, network, addr string, writev bool, opts ...Option) {
+	ts := &testUDPBatchServer{network: network, addr: addr, writev: writev, packets: 200}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.gso = len(opts) > 0
+	ts.run(ts, network+"://"+addr, append([]Option{WithUDPBatchSize(16), WithReadBufferCap(1024)}, opts...)...)
+	assert.EqualValues(t, ts.packets, atomic.LoadInt32(&ts.received))
+}
+
+func TestUDPSession(t *testing.T) {
+	t.Run("udp", func(t *testing.T) {
+		testUDPSession(t, "udp", ":9990")
+	})
+	t.Run("udp-batch", func(t *testing.T) {
+		testUDPSession(t, "udp", ":9991", WithUDPBatchSize(16))
+	})
+	t.Run("udp-reuseport", func(t *testing.T) {
+		testUDPSession(t, "udp", ":9992", WithReusePort(true), WithMulticore(true))
+	})
+}
+
+type testUDPSessionServer struct {
+	*testDrivenServer
+	network  string
+	addr     string
+	clients  int
+	packets  int
+	opened   int32
+	closed   int32
+	expired  int32
+	received int32
+}
+
+func (t *testUDPSessionServer) OnOpen(c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&t.opened, 1)
+	c.SetContext(0)
+	// The sessions outlive OnTraffic, so they can be written to asynchronously.
+	go func() { _ = c.AsyncWrite([]byte("welcome")) }()
+	return
+}
+
+func (t *testUDPSessionServer) OnClose(c Conn, err error) (action Action) {
+	if errors.Is(err, gerr.ErrUDPSessionExpired) {
+		atomic.AddInt32(&t.expired, 1)
+	}
+	atomic.AddInt32(&t.closed, 1)
+	return
+}
+
+func (t *testUDPSessionServer) OnTraffic(c Conn) (action Action) {
+	atomic.AddInt32(&t.received, 1)
+	buf, _ := c.Next(-1)
+	if string(buf) == "bye" {
+		return Close
+	}
+	// The context is kept across the datagrams from the same peer.
+	n := c.Context().(int) + 1
+	c.SetContext(n)
+	_, _ = c.Write([]byte(strconv.Itoa(n)))
+	return
+}
+
+func (t *testUDPSessionServer) runClient() {
+	for i := 0; i < t.clients; i++ {
+		conn, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		buf := make([]byte, 64)
+		for j := 1; j <= t.packets; j++ {
+			_, err = conn.Write([]byte("hello"))
+			require.NoError(t.tester, err)
+			var replies []string
+			for len(replies) == 0 || (j == 1 && len(replies) < 2) {
+				_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				n, err := conn.Read(buf)
+				require.NoError(t.tester, err)
+				replies = append(replies, string(buf[:n]))
+			}
+			if j == 1 {
+				require.ElementsMatch(t.tester, []string{"welcome", "1"}, replies)
+			} else {
+				require.Equal(t.tester, []string{strconv.Itoa(j)}, replies)
+			}
+		}
+		// The first client closes its session, the others are left to expire.
+		if i == 0 {
+			_, err = conn.Write([]byte("bye"))
+			require.NoError(t.tester, err)
+		}
+		_ = conn.Close()
+	}
+	require.Eventually(t.tester, func() bool {
+		return atomic.LoadInt32(&t.closed) == int32(t.clients)
+	}, 5*time.Second, 10*time.Millisecond)
+	require.EqualValues(t.tester, t.clients-1, atomic.LoadInt32(&t.expired))
+	require.EqualValues(t.tester, 0, t.eng.CountConnections())
+}
+
+func testUDPSession(t *testing.T, network, addr string, opts ...Option) {
+	ts := &testUDPSessionServer{network: network, addr: addr, clients: 3, packets: 10}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, network+"://"+addr, append([]Option{WithUDPSessionTimeout(200 * time.Millisecond)}, opts...)...)
+	assert.EqualValues(t, ts.clients, atomic.LoadInt32(&ts.opened))
+	assert.EqualValues(t, ts.clients*ts.packets+1, atomic.LoadInt32(&ts.received))
+}
+
+func TestUDPMulticast(t *testing.T) {
+	var (
+		ifi  *net.Interface
+		addr net.IP
+	)
+	ifs, _ := net.Interfaces()
+	for i := range ifs {
+		if ifs[i].Flags&net.FlagUp == 0 || ifs[i].Flags&net.FlagMulticast == 0 || ifs[i].Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := ifs[i].Addrs()
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				ifi, addr = &ifs[i], ipNet.IP.To4()
+				break
+			}
+		}
+		if ifi != nil {
+			break
+		}
+	}
+	if ifi == nil {
+		t.Skip("no multicast interface with IPv4 address")
+	}
+
+	t.Run("udp", func(t *testing.T) {
+		testUDPMulticast(t, ifi, addr, "239.255.77.1:9993", nil, WithMulticastInterface(ifi.Name))
+	})
+	t.Run("udp-multicore", func(t *testing.T) {
+		testUDPMulticast(t, ifi, addr, "239.255.77.2:9994", nil,
+			WithMulticastInterface(ifi.Name), WithMulticore(true), WithReusePort(true))
+	})
+	t.Run("udp-ssm", func(t *testing.T) {
+		testUDPMulticast(t, ifi, addr, "232.255.77.3:9995", addr,
+			WithMulticastInterface(ifi.Name), WithMulticastSources(addr.String()), WithMulticastTTL(2))
+	})
+}
+
+type testUDPMulticastServer struct {
+	*testDrivenServer
+	ifi      *net.Interface
+	ifaddr   net.IP
+	group    *net.UDPAddr
+	source   net.IP
+	received int32
+}
+
+func (t *testUDPMulticastServer) OnTraffic(c Conn) (action Action) {
+	atomic.AddInt32(&t.received, 1)
+	require.EqualValues(t.tester, 0, c.(ListenerIDConn).ListenerID())
+	buf, _ := c.Next(-1)
+	_, _ = c.Write(buf)
+	return
+}
+
+// roundTrip sends a datagram to the multicast group and reports whether it's echoed back.
+func (t *testUDPMulticastServer) roundTrip(conn *net.UDPConn, data string) bool {
+	_, err := conn.WriteToUDP([]byte(data), t.group)
+	require.NoError(t.tester, err)
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		return false
+	}
+	require.Equal(t.tester, data, string(buf[:n]))
+	return true
+}
+
+func (t *testUDPMulticastServer) runClient() {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: t.ifaddr})
+	require.NoError(t.tester, err)
+	defer conn.Close()
+	rc, err := conn.SyscallConn()
+	require.NoError(t.tester, err)
+	// Send the multicast datagrams through the interface the server joins the group on.
+	_ = rc.Control(func(fd uintptr) {
+		var a [4]byte
+		copy(a[:], t.ifaddr)
+		err = unix.SetsockoptInet4Addr(int(fd), unix.IPPROTO_IP, unix.IP_MULTICAST_IF, a)
+	})
+	require.NoError(t.tester, err)
+
+	require.True(t.tester, t.roundTrip(conn, "hello"), "no reply from the multicast group")
+	var sources []net.IP
+	if t.source != nil {
+		sources = append(sources, t.source)
+	}
+	require.ErrorIs(t.tester, t.eng.LeaveGroup(1, t.ifi, t.group.IP, sources...), gerr.ErrNotMulticastListener)
+	require.NoError(t.tester, t.eng.LeaveGroup(0, t.ifi, t.group.IP, sources...))
+	require.False(t.tester, t.roundTrip(conn, "left"), "reply after leaving the multicast group")
+	require.NoError(t.tester, t.eng.JoinGroup(0, t.ifi, t.group.IP, sources...))
+	require.True(t.tester, t.roundTrip(conn, "joined"), "no reply after rejoining the multicast group")
+}
+
+func testUDPMulticast(t *testing.T, ifi *net.Interface, ifaddr net.IP, addr string, source net.IP, opts ...Option) {
+	group, err := net.ResolveUDPAddr("udp4", addr)
+	require.NoError(t, err)
+	ts := &testUDPMulticastServer{ifi: ifi, ifaddr: ifaddr, group: group, source: source}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.runMulti(ts, []string{"udp://" + addr, "udp://:9996"}, opts...)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&ts.received))
+}
+
+func TestUDPPktInfo(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the packet information is only available on Linux")
+	}
+	t.Run("udp4", func(t *testing.T) {
+		testUDPPktInfo(t, "udp4", ":9997")
+	})
+	t.Run("udp4-batch", func(t *testing.T) {
+		testUDPPktInfo(t, "udp4", ":9998", WithUDPBatchSize(16))
+	})
+	t.Run("udp-dual-stack", func(t *testing.T) {
+		testUDPPktInfo(t, "udp", ":9999")
+	})
+	t.Run("udp4-session", func(t *testing.T) {
+		testUDPPktInfo(t, "udp4", ":10000", WithUDPSessionTimeout(time.Second))
+	})
+}
+
+type testUDPPktInfoServer struct {
+	*testDrivenServer
+	port string
+}
+
+func (t *testUDPPktInfoServer) OnTraffic(c Conn) (action Action) {
+	_, _ = c.Discard(-1)
+	_, _ = c.Write([]byte(c.LocalAddr().String()))
+	return
+}
+
+func (t *testUDPPktInfoServer) runClient() {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t.tester, err)
+	defer conn.Close()
+	buf := make([]byte, 64)
+	port, _ := strconv.Atoi(t.port[1:])
+	// The replies would leave from 127.0.0.1 which the kernel picks for the client without
+	// the packet information.
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3", "127.0.0.2"} {
+		dst := &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
+		_, err = conn.WriteToUDP([]byte("hello"), dst)
+		require.NoError(t.tester, err)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, from, err := conn.ReadFromUDP(buf)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, dst.String(), from.String())
+		require.Equal(t.tester, dst.String(), string(buf[:n]))
+	}
+}
+
+func testUDPPktInfo(t *testing.T, network, port string, opts ...Option) {
+	ts := &testUDPPktInfoServer{port: port}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, network+"://"+port, opts...)
+}
+
+func TestUnixgramAndUnixpacket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the unixgram clients are only named on Linux and unixpacket is unsupported on darwin")
+	}
+	t.Run("1-loop", func(t *testing.T) {
+		testUnixgramAndUnixpacket(t, "gnet_dgram1.sock", "gnet_packet1.sock")
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testUnixgramAndUnixpacket(t, "gnet_dgram2.sock", "gnet_packet2.sock", WithMulticore(true), WithUDPBatchSize(16))
+	})
+}
+
+type testUnixClientEvents struct {
+	*BuiltinEventEngine
+	messages chan string
+}
+
+func (ev *testUnixClientEvents) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	ev.messages <- c.RemoteAddr().Network() + ":" + string(buf)
+	return
+}
+
+type testUnixServer struct {
+	*testDrivenServer
+	addrs    []string
+	received int32
+}
+
+func (t *testUnixServer) OnTraffic(c Conn) (action Action) {
+	atomic.AddInt32(&t.received, 1)
+	require.Equal(t.tester, c.LocalAddr().Network(), c.RemoteAddr().Network())
+	buf, _ := c.Next(-1)
+	_, _ = c.Write(buf)
+	return
+}
+
+func (t *testUnixServer) runClient() {
+	messages := []string{"a", "bb", "ccc", "dddd"}
+
+	// The messages sent back-to-back are received one by one.
+	conn, err := net.Dial("unixpacket", t.addrs[1])
+	require.NoError(t.tester, err)
+	for _, m := range messages {
+		_, err = conn.Write([]byte(m))
+		require.NoError(t.tester, err)
+	}
+	buf := make([]byte, 64)
+	for _, m := range messages {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, m, string(buf[:n]))
+	}
+	_ = conn.Close()
+
+	ev := &testUnixClientEvents{messages: make(chan string, 16)}
+	client, err := NewClient(ev)
+	require.NoError(t.tester, err)
+	require.NoError(t.tester, client.Start())
+	defer client.Stop() //nolint:errcheck
+	for i, network := range []string{"unixgram", "unixpacket"} {
+		c, err := client.Dial(network, t.addrs[i])
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, network, c.LocalAddr().Network())
+		for _, m := range messages {
+			err = c.AsyncWrite([]byte(m))
+			require.NoError(t.tester, err)
+			select {
+			case got := <-ev.messages:
+				require.Equal(t.tester, network+":"+m, got)
+			case <-time.After(5 * time.Second):
+				require.FailNow(t.tester, "no reply", "network: %s, message: %s", network, m)
+			}
+		}
+		require.NoError(t.tester, c.Close())
+	}
+}
+
+func testUnixgramAndUnixpacket(t *testing.T, dgramAddr, packetAddr string, opts ...Option) {
+	ts := &testUnixServer{addrs: []string{dgramAddr, packetAddr}}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.runMulti(ts, []string{"unixgram://" + dgramAddr, "unixpacket://" + packetAddr}, opts...)
+	assert.EqualValues(t, 12, atomic.LoadInt32(&ts.received))
+	for _, addr := range ts.addrs {
+		_, err := os.Stat(addr)
+		assert.True(t, os.IsNotExist(err), "socket file %s is not removed", addr)
+	}
+}
+
+func TestFdPassing(t *testing.T) {
+	t.Run("1-loop", func(t *testing.T) {
+		testFdPassing(t, "gnet_fds1.sock")
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testFdPassing(t, "gnet_fds2.sock", WithMulticore(true))
+	})
+}
+
+type testFdPassingServer struct {
+	*testDrivenServer
+	addr string
+}
+
+func (t *testFdPassingServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	switch string(buf) {
+	case "claim":
+		// Write to the pipe passed by the client and pass another pipe back.
+		fds := c.(FdPassingConn).ReceivedFds()
+		require.Len(t.tester, fds, 1)
+		flags, err := unix.FcntlInt(uintptr(fds[0]), unix.F_GETFD, 0)
+		require.NoError(t.tester, err)
+		require.NotZero(t.tester, flags&unix.FD_CLOEXEC, "received fds should be close-on-exec")
+		_, err = unix.Write(fds[0], []byte("from server"))
+		require.NoError(t.tester, err)
+		require.NoError(t.tester, unix.Close(fds[0]))
+
+		var p [2]int
+		require.NoError(t.tester, unix.Pipe(p[:]))
+		_, err = unix.Write(p[1], []byte("from pipe"))
+		require.NoError(t.tester, err)
+		require.NoError(t.tester, unix.Close(p[1]))
+		// Pile up the outbound buffer so that the fds are queued behind the pending data.
+		_, _ = c.Write(bytes.Repeat([]byte{'x'}, 4*1024*1024))
+		_, err = c.(FdPassingConn).WriteWithFds([]byte("passed"), p[:1])
+		require.NoError(t.tester, err)
+		require.NoError(t.tester, unix.Close(p[0]))
+	case "ignore":
+		// Leave the fds unclaimed, which ought to be closed by the event-loop.
+		_, _ = c.Write([]byte("ignored"))
+	}
+	return
+}
+
+func (t *testFdPassingServer) runClient() {
+	conn, err := net.Dial("unix", t.addr)
+	require.NoError(t.tester, err)
+	defer conn.Close() //nolint:errcheck
+	uc := conn.(*net.UnixConn)
+	_ = uc.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var p [2]int
+	require.NoError(t.tester, unix.Pipe(p[:]))
+	_, _, err = uc.WriteMsgUnix([]byte("claim"), unix.UnixRights(p[1]), nil)
+	require.NoError(t.tester, err)
+	require.NoError(t.tester, unix.Close(p[1]))
+	buf := make([]byte, 64)
+	n, err := unix.Read(p[0], buf)
+	require.NoError(t.tester, err)
+	require.Equal(t.tester, "from server", string(buf[:n]))
+	require.NoError(t.tester, unix.Close(p[0]))
+
+	// The fds may be delivered along with some of the data ahead of them.
+	var (
+		received []byte
+		oobn     int
+		data     = make([]byte, 64*1024)
+		oob      = make([]byte, unix.CmsgSpace(4))
+	)
+	for len(received) < 4*1024*1024+len("passed") {
+		var m int
+		n, m, _, _, err = uc.ReadMsgUnix(data, oob[oobn:])
+		require.NoError(t.tester, err)
+		received = append(received, data[:n]...)
+		oobn += m
+	}
+	require.Equal(t.tester, "passed", string(received[4*1024*1024:]))
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	require.NoError(t.tester, err)
+	require.Len(t.tester, msgs, 1)
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	require.NoError(t.tester, err)
+	require.Len(t.tester, fds, 1)
+	n, err = unix.Read(fds[0], buf)
+	require.NoError(t.tester, err)
+	require.Equal(t.tester, "from pipe", string(buf[:n]))
+	require.NoError(t.tester, unix.Close(fds[0]))
+
+	require.NoError(t.tester, unix.Pipe(p[:]))
+	_, _, err = uc.WriteMsgUnix([]byte("ignore"), unix.UnixRights(p[1]), nil)
+	require.NoError(t.tester, err)
+	require.NoError(t.tester, unix.Close(p[1]))
+	n, err = uc.Read(buf)
+	require.NoError(t.tester, err)
+	require.Equal(t.tester, "ignored", string(buf[:n]))
+	// The write end of the pipe is closed by the server, so the read end gets EOF.
+	n, err = unix.Read(p[0], buf)
+	require.NoError(t.tester, err)
+	require.Zero(t.tester, n)
+	require.NoError(t.tester, unix.Close(p[0]))
+}
+
+func testFdPassing(t *testing.T, addr string, opts ...Option) {
+	ts := &testFdPassingServer{addr: addr}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, "unix://"+addr, opts...)
+}
+
+func TestPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the peer credentials are only available on Linux")
+	}
+	t.Run("1-loop", func(t *testing.T) {
+		testPeerCredentials(t, "gnet_cred1.sock")
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testPeerCredentials(t, "gnet_cred2.sock", WithMulticore(true))
+	})
+}
+
+type testPeerCredentialsServer struct {
+	*testDrivenServer
+	addr       string
+	authorized int32
+	opened     int32
+}
+
+func (t *testPeerCredentialsServer) filter(cred *Credentials) bool {
+	require.EqualValues(t.tester, os.Getpid(), cred.PID)
+	require.EqualValues(t.tester, os.Geteuid(), cred.UID)
+	require.EqualValues(t.tester, os.Getegid(), cred.GID)
+	// Only authorize the first connection.
+	return atomic.AddInt32(&t.authorized, 1) == 1
+}
+
+func (t *testPeerCredentialsServer) OnOpen(c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&t.opened, 1)
+	cred, err := c.(PeerCredentialsConn).PeerCredentials()
+	require.NoError(t.tester, err)
+	require.EqualValues(t.tester, os.Getpid(), cred.PID)
+	out = []byte("authorized")
+	return
+}
+
+func (t *testPeerCredentialsServer) runClient() {
+	buf := make([]byte, 64)
+	authorized, err := net.Dial("unix", t.addr)
+	require.NoError(t.tester, err)
+	defer authorized.Close() //nolint:errcheck
+	_ = authorized.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := authorized.Read(buf)
+	require.NoError(t.tester, err)
+	require.Equal(t.tester, "authorized", string(buf[:n]))
+
+	// The second connection is closed by the engine without firing OnOpen.
+	rejected, err := net.Dial("unix", t.addr)
+	require.NoError(t.tester, err)
+	defer rejected.Close() //nolint:errcheck
+	_ = rejected.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = rejected.Read(buf)
+	require.ErrorIs(t.tester, err, io.EOF)
+}
+
+func testPeerCredentials(t *testing.T, addr string, opts ...Option) {
+	ts := &testPeerCredentialsServer{addr: addr}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, "unix://"+addr, append([]Option{WithPeerCredentialsFilter(ts.filter)}, opts...)...)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&ts.authorized))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&ts.opened))
+}
+
+func TestUnixListenerPermAndAbstract(t *testing.T) {
+	t.Run("perm", func(t *testing.T) {
+		testUnixListenerPermAndAbstract(t, "gnet_perm.sock",
+			WithUnixSocketMode(0o600), WithUnixSocketOwner(strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())))
+	})
+	t.Run("abstract", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("the abstract namespace of unix sockets is only available on Linux")
+		}
+		testUnixListenerPermAndAbstract(t, "@gnet_abstract.sock", WithUnixSocketMode(0o600))
+	})
+}
+
+type testUnixListenerServer struct {
+	*testDrivenServer
+	addr string
+}
+
+func (t *testUnixListenerServer) OnBoot(_ Engine) (action Action) {
+	fi, err := os.Stat(t.addr)
+	if t.addr[0] == '@' {
+		require.True(t.tester, os.IsNotExist(err), "the abstract unix socket shouldn't have a file")
+	} else {
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, os.ModeSocket|0o600, fi.Mode())
+	}
+	return
+}
+
+func testUnixListenerPermAndAbstract(t *testing.T, addr string, opts ...Option) {
+	ts := &testUnixListenerServer{addr: addr}
+	ts.testDrivenServer = newTestDrivenServer(t, echoClient(t, "unix", addr))
+	ts.run(ts, "unix://"+addr, opts...)
+	_, err := os.Stat(addr)
+	assert.True(t, os.IsNotExist(err), "the socket file should be removed")
+}
+
+func TestHalfClose(t *testing.T) {
+	t.Run("tcp", func(t *testing.T) {
+		t.Run("1-loop", func(t *testing.T) {
+			testHalfClose(t, "tcp", ":7210")
+		})
+		t.Run("N-loop", func(t *testing.T) {
+			testHalfClose(t, "tcp", ":7211", WithMulticore(true))
+		})
+	})
+	t.Run("unix", func(t *testing.T) {
+		t.Run("1-loop", func(t *testing.T) {
+			testHalfClose(t, "unix", "gnet_half1.sock")
+		})
+		t.Run("N-loop", func(t *testing.T) {
+			testHalfClose(t, "unix", "gnet_half2.sock", WithMulticore(true))
+		})
+	})
+}
+
+type testHalfCloseServer struct {
+	*testDrivenServer
+	network string
+	addr    string
+	readEOF int32
+	closed  int32
+}
+
+func (t *testHalfCloseServer) OnTraffic(_ Conn) (action Action) {
+	// Leave the request in the inbound buffer until the peer is done with sending.
+	return
+}
+
+func (t *testHalfCloseServer) OnReadEOF(c Conn) (action Action) {
+	atomic.AddInt32(&t.readEOF, 1)
+	buf, _ := c.Next(-1)
+	require.Equal(t.tester, "request", string(buf))
+	_, err := c.Write(bytes.Repeat([]byte{'x'}, 4*1024*1024))
+	require.NoError(t.tester, err)
+	require.NoError(t.tester, c.(HalfCloseConn).CloseWrite())
+	return
+}
+
+func (t *testHalfCloseServer) OnClose(_ Conn, err error) (action Action) {
+	require.NoError(t.tester, err)
+	atomic.AddInt32(&t.closed, 1)
+	return
+}
+
+func (t *testHalfCloseServer) runClient() {
+	conn, err := net.Dial(t.network, t.addr)
+	require.NoError(t.tester, err)
+	defer conn.Close() //nolint:errcheck
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("request"))
+	require.NoError(t.tester, err)
+	require.NoError(t.tester, conn.(interface{ CloseWrite() error }).CloseWrite())
+	// The response is sent in full before the server shuts down its writing side.
+	resp, err := io.ReadAll(conn)
+	require.NoError(t.tester, err)
+	require.Len(t.tester, resp, 4*1024*1024)
+	require.Eventually(t.tester, func() bool { return atomic.LoadInt32(&t.closed) == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func testHalfClose(t *testing.T, network, addr string, opts ...Option) {
+	ts := &testHalfCloseServer{network: network, addr: addr}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, network+"://"+addr, append([]Option{WithHalfClose(true)}, opts...)...)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&ts.readEOF))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&ts.closed))
+}
+
+func TestCloseWithErrorAndAbort(t *testing.T) {
+	t.Run("1-loop", func(t *testing.T) {
+		testCloseWithErrorAndAbort(t, ":7212")
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testCloseWithErrorAndAbort(t, ":7213", WithMulticore(true))
+	})
+}
+
+var errTestCloseReason = errors.New("closed by application")
+
+type testCloseWithErrorAndAbortServer struct {
+	*testDrivenServer
+	addr    string
+	reasons chan error
+}
+
+func (t *testCloseWithErrorAndAbortServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	switch string(buf) {
+	case "close":
+		require.NoError(t.tester, c.(AbortableConn).CloseWithError(errTestCloseReason))
+	case "abort":
+		// The pending data is discarded rather than flushed.
+		_, _ = c.Write(bytes.Repeat([]byte{'x'}, 4*1024*1024))
+		require.NoError(t.tester, c.(AbortableConn).Abort())
+	}
+	return
+}
+
+func (t *testCloseWithErrorAndAbortServer) OnClose(_ Conn, err error) (action Action) {
+	t.reasons <- err
+	return
+}
+
+func (t *testCloseWithErrorAndAbortServer) runClient() {
+	conn, err := net.Dial("tcp", t.addr)
+	require.NoError(t.tester, err)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("close"))
+	require.NoError(t.tester, err)
+	_, err = conn.Read(make([]byte, 64))
+	require.ErrorIs(t.tester, err, io.EOF)
+	require.ErrorIs(t.tester, <-t.reasons, errTestCloseReason)
+	_ = conn.Close()
+
+	conn, err = net.Dial("tcp", t.addr)
+	require.NoError(t.tester, err)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("abort"))
+	require.NoError(t.tester, err)
+	_, err = io.ReadAll(conn)
+	require.ErrorIs(t.tester, err, syscall.ECONNRESET)
+	require.ErrorIs(t.tester, <-t.reasons, gerr.ErrConnectionAborted)
+	_ = conn.Close()
+}
+
+func testCloseWithErrorAndAbort(t *testing.T, addr string, opts ...Option) {
+	ts := &testCloseWithErrorAndAbortServer{addr: addr, reasons: make(chan error, 2)}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, "tcp://"+addr, opts...)
+}
+
+func TestCloseAfterFlush(t *testing.T) {
+	t.Run("1-loop", func(t *testing.T) {
+		testCloseAfterFlush(t, ":7214")
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testCloseAfterFlush(t, ":7215", WithMulticore(true))
+	})
+}
+
+type testCloseAfterFlushServer struct {
+	*testDrivenServer
+	addr   string
+	closed chan time.Time
+}
+
+func (t *testCloseAfterFlushServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	if string(buf) == "ping" {
+		_, _ = c.Write(buf)
+		return
+	}
+	// Close the connection with a large response pending in the outbound buffer.
+	_, _ = c.Write(bytes.Repeat([]byte{'x'}, 16*1024*1024))
+	return Close
+}
+
+func (t *testCloseAfterFlushServer) OnClose(_ Conn, err error) (action Action) {
+	require.NoError(t.tester, err)
+	t.closed <- time.Now()
+	return
+}
+
+func (t *testCloseAfterFlushServer) runClient() {
+	// The response is sent in full to a slow reader before the connection is closed.
+	conn, err := net.Dial("tcp", t.addr)
+	require.NoError(t.tester, err)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("flush"))
+	require.NoError(t.tester, err)
+	time.Sleep(100 * time.Millisecond)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t.tester, err)
+	require.Len(t.tester, resp, 16*1024*1024)
+	<-t.closed
+	_ = conn.Close()
+
+	// The response is discarded if the peer doesn't read it in time,
+	// and the event-loop keeps serving the other connections in the meantime.
+	stalled, err := net.Dial("tcp", t.addr)
+	require.NoError(t.tester, err)
+	defer stalled.Close() //nolint:errcheck
+	_, err = stalled.Write([]byte("stall"))
+	require.NoError(t.tester, err)
+	start := time.Now()
+	conn, err = net.Dial("tcp", t.addr)
+	require.NoError(t.tester, err)
+	defer conn.Close() //nolint:errcheck
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4)
+	for i := 0; i < 5; i++ {
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t.tester, err)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t.tester, err)
+		require.Equal(t.tester, "ping", string(buf))
+		time.Sleep(50 * time.Millisecond)
+	}
+	closedAt := <-t.closed
+	require.GreaterOrEqual(t.tester, closedAt.Sub(start), 900*time.Millisecond)
+}
+
+func testCloseAfterFlush(t *testing.T, addr string, opts ...Option) {
+	ts := &testCloseAfterFlushServer{addr: addr, closed: make(chan time.Time, 3)}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, "tcp://"+addr, append([]Option{WithCloseFlushTimeout(time.Second)}, opts...)...)
+}
+
+func TestSocketOptions(t *testing.T) {
+	t.Run("1-loop", func(t *testing.T) {
+		testSocketOptions(t, ":7218")
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testSocketOptions(t, ":7219", WithMulticore(true))
+	})
+}
+
+type testSocketOptionsServer struct {
+	*testDrivenServer
+	addr        string
+	listenerCtl int32
+	connCtl     int32
+	opened      int32
+}
+
+func (t *testSocketOptionsServer) control(fd int) error {
+	// Only the accepted connections have a peer.
+	if _, err := unix.Getpeername(fd); err != nil {
+		atomic.AddInt32(&t.listenerCtl, 1)
+	} else {
+		atomic.AddInt32(&t.connCtl, 1)
+	}
+	if v, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ACCEPTCONN); err == nil && v != 0 {
+		return errors.New("listener must not be listening yet")
+	}
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1)
+}
+
+func (t *testSocketOptionsServer) OnOpen(c Conn) (out []byte, action Action) {
+	err := c.(ControlConn).Control(func(fd int) error {
+		v, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE)
+		require.NoError(t.tester, err)
+		require.EqualValues(t.tester, 1, v)
+		return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE, 0)
+	})
+	require.NoError(t.tester, err)
+	err = c.(ControlConn).Control(func(fd int) error {
+		v, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE)
+		require.NoError(t.tester, err)
+		require.EqualValues(t.tester, 0, v)
+		return nil
+	})
+	require.NoError(t.tester, err)
+	atomic.AddInt32(&t.opened, 1)
+	return
+}
+
+func (t *testSocketOptionsServer) runClient() {
+	echoClient(t.tester, "tcp", t.addr)()
+
+	// The connections are closed right away when the callbacks fail.
+	fail := errors.New("rejected")
+	cli, err := NewClient(&BuiltinEventEngine{}, WithSocketOptions(func(int) error { return fail }))
+	require.NoError(t.tester, err)
+	require.NoError(t.tester, cli.Start())
+	defer cli.Stop() //nolint:errcheck
+	_, err = cli.Dial("tcp", t.addr)
+	require.ErrorIs(t.tester, err, fail)
+	// The connection is established before the client fails it, so the engine accepts it as well.
+	require.Eventually(t.tester, func() bool { return atomic.LoadInt32(&t.opened) == 2 },
+		5*time.Second, 10*time.Millisecond)
+}
+
+func testSocketOptions(t *testing.T, addr string, opts ...Option) {
+	ts := &testSocketOptionsServer{addr: addr}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, "tcp://"+addr, append([]Option{WithSocketOptions(ts.control)}, opts...)...)
+	// The stream listener is shared by all event-loops, so the callback runs on one listener.
+	assert.EqualValues(t, 1, atomic.LoadInt32(&ts.listenerCtl))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&ts.connCtl))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&ts.opened))
+}
+
+func TestAcceptBatch(t *testing.T) {
+	t.Run("tcp", func(t *testing.T) {
+		t.Run("1-loop", func(t *testing.T) {
+			testAcceptBatch(t, "tcp", ":7224")
+		})
+		t.Run("N-loop", func(t *testing.T) {
+			testAcceptBatch(t, "tcp", ":7225", WithMulticore(true))
+		})
+	})
+	t.Run("unix", func(t *testing.T) {
+		t.Run("1-loop", func(t *testing.T) {
+			testAcceptBatch(t, "unix", "gnet_accept_batch1.sock")
+		})
+		t.Run("N-loop", func(t *testing.T) {
+			testAcceptBatch(t, "unix", "gnet_accept_batch2.sock", WithMulticore(true))
+		})
+	})
+}
+
+type testAcceptBatchServer struct {
+	*testDrivenServer
+	network string
+	addr    string
+	clients int
+	opened  int32
+}
+
+func (t *testAcceptBatchServer) OnOpen(c Conn) (out []byte, action Action) {
+	err := c.(ControlConn).Control(func(fd int) error {
+		flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+		require.NoError(t.tester, err)
+		require.NotZero(t.tester, flags&unix.FD_CLOEXEC, "accepted socket must be close-on-exec")
+		flags, err = unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+		require.NoError(t.tester, err)
+		require.NotZero(t.tester, flags&unix.O_NONBLOCK, "accepted socket must be non-blocking")
+		return nil
+	})
+	require.NoError(t.tester, err)
+	atomic.AddInt32(&t.opened, 1)
+	return
+}
+
+func (t *testAcceptBatchServer) runClient() {
+	// Connect all clients at once so that they're pending in the backlog together.
+	conns := make([]net.Conn, t.clients)
+	for i := range conns {
+		c, err := net.Dial(t.network, t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close() //nolint:gocritic
+		conns[i] = c
+	}
+	for i, c := range conns {
+		requireEcho(t.tester, c, "hello-"+strconv.Itoa(i))
+	}
+}
+
+func testAcceptBatch(t *testing.T, network, addr string, opts ...Option) {
+	ts := &testAcceptBatchServer{network: network, addr: addr, clients: 32}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, network+"://"+addr, append([]Option{WithListenBacklog(64), WithAcceptBatchSize(8)}, opts...)...)
+	assert.EqualValues(t, ts.clients, atomic.LoadInt32(&ts.opened))
+}
+
+func TestConnectionLimits(t *testing.T) {
+	t.Run("max-conns", func(t *testing.T) {
+		t.Run("1-loop", func(t *testing.T) {
+			testConnectionLimits(t, ":7226", WithMaxConns(2))
+		})
+		t.Run("N-loop", func(t *testing.T) {
+			testConnectionLimits(t, ":7227", WithMaxConns(2), WithMulticore(true))
+		})
+	})
+	t.Run("max-conns-per-ip", func(t *testing.T) {
+		t.Run("1-loop", func(t *testing.T) {
+			testConnectionLimits(t, ":7228", WithMaxConnsPerIP(2))
+		})
+		t.Run("N-loop", func(t *testing.T) {
+			testConnectionLimits(t, ":7229", WithMaxConnsPerIP(2), WithMulticore(true))
+		})
+	})
+	t.Run("max-conns-per-cidr", func(t *testing.T) {
+		t.Run("1-loop", func(t *testing.T) {
+			testConnectionLimits(t, ":7230", WithMaxConnsPerCIDR(2, 8, 64))
+		})
+		t.Run("N-loop", func(t *testing.T) {
+			testConnectionLimits(t, ":7231", WithMaxConnsPerCIDR(2, 8, 64), WithMulticore(true))
+		})
+	})
+}
+
+type testConnectionLimitsServer struct {
+	*testDrivenServer
+	addr   string
+	closed chan struct{}
+}
+
+func (t *testConnectionLimitsServer) OnClose(_ Conn, _ error) (action Action) {
+	t.closed <- struct{}{}
+	return
+}
+
+func (t *testConnectionLimitsServer) runClient() {
+	conns := make([]net.Conn, 2)
+	for i := range conns {
+		c, err := net.Dial("tcp", "127.0.0.1"+t.addr)
+		require.NoError(t.tester, err)
+		defer c.Close() //nolint:gocritic
+		requireEcho(t.tester, c, "hello")
+		conns[i] = c
+	}
+
+	// The connection exceeding the limit receives the rejection payload and gets closed.
+	rejected, err := net.Dial("tcp", "127.0.0.1"+t.addr)
+	require.NoError(t.tester, err)
+	_ = rejected.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := io.ReadAll(rejected)
+	require.NoError(t.tester, err)
+	require.EqualValues(t.tester, "busy", resp)
+	_ = rejected.Close()
+	require.EqualValues(t.tester, 1, t.eng.CountRejectedConnections())
+	require.EqualValues(t.tester, 2, t.eng.CountConnections())
+
+	// The new connection is admitted once one of the connections is closed.
+	_ = conns[0].Close()
+	<-t.closed
+	admitted, err := net.Dial("tcp", "127.0.0.1"+t.addr)
+	require.NoError(t.tester, err)
+	defer admitted.Close()
+	requireEcho(t.tester, admitted, "hello")
+	require.EqualValues(t.tester, 1, t.eng.CountRejectedConnections())
+}
+
+func testConnectionLimits(t *testing.T, addr string, opts ...Option) {
+	ts := &testConnectionLimitsServer{addr: addr, closed: make(chan struct{}, 4)}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, "tcp://"+addr, append([]Option{WithRejectPayload([]byte("busy"))}, opts...)...)
+}
+
+func TestAcceptFilter(t *testing.T) {
+	t.Run("1-loop", func(t *testing.T) {
+		testAcceptFilter(t, ":7232")
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testAcceptFilter(t, ":7233", WithMulticore(true))
+	})
+}
+
+type testAcceptFilterServer struct {
+	*testDrivenServer
+	*CIDRFilter
+	addr     string
+	accepted int32
+	opened   int32
+}
+
+func (t *testAcceptFilterServer) OnAccept(remote, local net.Addr) (action Action) {
+	atomic.AddInt32(&t.accepted, 1)
+	require.EqualValues(t.tester, "127.0.0.1", remote.(*net.TCPAddr).IP.String())
+	require.EqualValues(t.tester, t.addr, ":"+strconv.Itoa(local.(*net.TCPAddr).Port))
+	return t.CIDRFilter.OnAccept(remote, local)
+}
+
+func (t *testAcceptFilterServer) OnOpen(_ Conn) (out []byte, action Action) {
+	atomic.AddInt32(&t.opened, 1)
+	return
+}
+
+func (t *testAcceptFilterServer) OnClose(_ Conn, _ error) (action Action) {
+	require.NotZero(t.tester, atomic.LoadInt32(&t.opened), "OnClose must not fire for the rejected connections")
+	return
+}
+
+func (t *testAcceptFilterServer) runClient() {
+	// The connections out of the allow list are closed right away.
+	rejected, err := net.Dial("tcp", "127.0.0.1"+t.addr)
+	require.NoError(t.tester, err)
+	_ = rejected.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := io.ReadAll(rejected)
+	if err != nil {
+		require.ErrorIs(t.tester, err, syscall.ECONNRESET)
+	}
+	require.Empty(t.tester, resp)
+	_ = rejected.Close()
+	require.EqualValues(t.tester, 1, atomic.LoadInt32(&t.accepted))
+	require.Zero(t.tester, atomic.LoadInt32(&t.opened))
+
+	// The new lists take effect without restarting the engine.
+	require.Error(t.tester, t.Reload([]string{"127.0.0.1/33"}, nil))
+	require.NoError(t.tester, t.Reload([]string{"10.0.0.0/8", "127.0.0.1"}, []string{"192.0.2.0/24"}))
+	conn, err := net.Dial("tcp", "127.0.0.1"+t.addr)
+	require.NoError(t.tester, err)
+	defer conn.Close()
+	requireEcho(t.tester, conn, "hello")
+	require.EqualValues(t.tester, 2, atomic.LoadInt32(&t.accepted))
+	require.EqualValues(t.tester, 1, atomic.LoadInt32(&t.opened))
+}
+
+func testAcceptFilter(t *testing.T, addr string, opts ...Option) {
+	filter, err := NewCIDRFilter([]string{"10.0.0.0/8"}, nil)
+	require.NoError(t, err)
+	ts := &testAcceptFilterServer{addr: addr, CIDRFilter: filter}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, "tcp://"+addr, opts...)
+}
+
+func TestCIDRFilter(t *testing.T) {
+	_, err := NewCIDRFilter([]string{"not-an-ip"}, nil)
+	require.Error(t, err)
+
+	f, err := NewCIDRFilter(nil, []string{"192.0.2.0/24", "2001:db8::1"})
+	require.NoError(t, err)
+	tcpAddr := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234} }
+	assert.EqualValues(t, Close, f.OnAccept(tcpAddr("192.0.2.1"), nil))
+	assert.EqualValues(t, Close, f.OnAccept(tcpAddr("::ffff:192.0.2.1"), nil))
+	assert.EqualValues(t, Close, f.OnAccept(tcpAddr("2001:db8::1"), nil))
+	assert.EqualValues(t, None, f.OnAccept(tcpAddr("2001:db8::2"), nil))
+	assert.EqualValues(t, None, f.OnAccept(tcpAddr("198.51.100.1"), nil))
+	assert.EqualValues(t, None, f.OnAccept(&net.UnixAddr{Name: "gnet.sock", Net: "unix"}, nil))
+
+	// The deny list takes precedence over the allow list.
+	require.NoError(t, f.Reload([]string{"192.0.2.0/24"}, []string{"192.0.2.128/25"}))
+	assert.EqualValues(t, None, f.OnAccept(tcpAddr("192.0.2.1"), nil))
+	assert.EqualValues(t, Close, f.OnAccept(tcpAddr("192.0.2.129"), nil))
+	assert.EqualValues(t, Close, f.OnAccept(tcpAddr("198.51.100.1"), nil))
+}
+
+func TestPauseAccept(t *testing.T) {
+	t.Run("1-loop", func(t *testing.T) {
+		testPauseAccept(t, ":7234")
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testPauseAccept(t, ":7235", WithMulticore(true))
+	})
+}
+
+type testPauseAcceptServer struct {
+	*testDrivenServer
+	addr   string
+	opened int32
+}
+
+func (t *testPauseAcceptServer) OnOpen(_ Conn) (out []byte, action Action) {
+	atomic.AddInt32(&t.opened, 1)
+	return
+}
+
+func (t *testPauseAcceptServer) runClient() {
+	require.NoError(t.tester, t.eng.PauseAccept())
+
+	// The new connections stay in the backlog while accepting is paused.
+	conn, err := net.Dial("tcp", "127.0.0.1"+t.addr)
+	require.NoError(t.tester, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t.tester, err)
+	buf := make([]byte, 5)
+	_ = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, err = io.ReadFull(conn, buf)
+	require.ErrorIs(t.tester, err, os.ErrDeadlineExceeded)
+	require.Zero(t.tester, atomic.LoadInt32(&t.opened))
+
+	require.NoError(t.tester, t.eng.ResumeAccept())
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t.tester, err)
+	require.EqualValues(t.tester, "hello", buf)
+	require.EqualValues(t.tester, 1, atomic.LoadInt32(&t.opened))
+}
+
+func testPauseAccept(t *testing.T, addr string, opts ...Option) {
+	ts := &testPauseAcceptServer{addr: addr}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, "tcp://"+addr, opts...)
+}
+
+func TestAcceptRateLimit(t *testing.T) {
+	t.Run("1-loop", func(t *testing.T) {
+		testAcceptRateLimit(t, ":7236")
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testAcceptRateLimit(t, ":7237", WithMulticore(true))
+	})
+}
+
+type testAcceptRateLimitServer struct {
+	*testDrivenServer
+	addr string
+}
+
+func (t *testAcceptRateLimitServer) runClient() {
+	// 10 connections/sec with a burst of 2, the last of the 6 connections is accepted after 400ms.
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", "127.0.0.1"+t.addr)
+			require.NoError(t.tester, err)
+			defer conn.Close()
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			requireEcho(t.tester, conn, "hello")
+		}()
+	}
+	wg.Wait()
+	require.GreaterOrEqual(t.tester, time.Since(start), 300*time.Millisecond)
+}
+
+func testAcceptRateLimit(t *testing.T, addr string, opts ...Option) {
+	ts := &testAcceptRateLimitServer{addr: addr}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, "tcp://"+addr, append([]Option{WithAcceptRateLimit(10, 2)}, opts...)...)
+}
+
 // Test should not panic when we wake-up server_closed conn.
 func TestClosedWakeUp(t *testing.T) {
 	events := &testClosedWakeUpServer{
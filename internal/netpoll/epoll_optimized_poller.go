@@ -235,6 +235,22 @@ func (p *Poller) ModReadWrite(pa *PollAttachment) error {
 	return os.NewSyscallError("epoll_ctl mod", epollCtl(p.fd, unix.EPOLL_CTL_MOD, pa.FD, &ev))
 }
 
+// ModWrite renews the given file-descriptor with writable event only in the poller.
+func (p *Poller) ModWrite(pa *PollAttachment) error {
+	var ev epollevent
+	ev.events = writeEvents
+	*(**PollAttachment)(unsafe.Pointer(&ev.data)) = pa
+	return os.NewSyscallError("epoll_ctl mod", epollCtl(p.fd, unix.EPOLL_CTL_MOD, pa.FD, &ev))
+}
+
+// ModNone renews the given file-descriptor without readable or writable events in the poller,
+// only the exceptional events are reported.
+func (p *Poller) ModNone(pa *PollAttachment) error {
+	var ev epollevent
+	*(**PollAttachment)(unsafe.Pointer(&ev.data)) = pa
+	return os.NewSyscallError("epoll_ctl mod", epollCtl(p.fd, unix.EPOLL_CTL_MOD, pa.FD, &ev))
+}
+
 // Delete removes the given file-descriptor from the poller.
 func (p *Poller) Delete(fd int) error {
 	return os.NewSyscallError("epoll_ctl del", epollCtl(p.fd, unix.EPOLL_CTL_DEL, fd, nil))
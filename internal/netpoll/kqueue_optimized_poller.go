@@ -244,6 +244,36 @@ func (p *Poller) ModReadWrite(pa *PollAttachment) error {
 	return os.NewSyscallError("kevent add", err)
 }
 
+// ModWrite renews the given file-descriptor with writable event only in the poller.
+func (p *Poller) ModWrite(pa *PollAttachment) error {
+	if err := p.ModReadWrite(pa); err != nil {
+		return err
+	}
+	return p.deleteFilter(pa, unix.EVFILT_READ)
+}
+
+// ModNone renews the given file-descriptor without readable or writable events in the poller.
+func (p *Poller) ModNone(pa *PollAttachment) error {
+	if err := p.deleteFilter(pa, unix.EVFILT_WRITE); err != nil {
+		return err
+	}
+	return p.deleteFilter(pa, unix.EVFILT_READ)
+}
+
+// deleteFilter removes the filter of the given file-descriptor from the poller if it exists.
+func (p *Poller) deleteFilter(pa *PollAttachment, filter int16) error {
+	var evs [1]unix.Kevent_t
+	evs[0].Ident = uint64(pa.FD)
+	evs[0].Flags = unix.EV_DELETE
+	evs[0].Filter = filter
+	evs[0].Udata = (*byte)(unsafe.Pointer(pa))
+	_, err := unix.Kevent(p.fd, evs[:], nil, nil)
+	if err == unix.ENOENT {
+		err = nil
+	}
+	return os.NewSyscallError("kevent delete", err)
+}
+
 // Delete removes the given file-descriptor from the poller.
 func (p *Poller) Delete(_ int) error {
 	return nil
@@ -0,0 +1,65 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || darwin
+// +build linux freebsd dragonfly darwin
+
+package socket
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/v2/pkg/errors"
+)
+
+// ProbeSocket determines the network of a pre-opened socket by probing its type and address family,
+// it returns the network ("tcp", "udp", "unix", "unixgram" or "unixpacket") along with the local address of the socket.
+func ProbeSocket(fd int) (network string, netAddr net.Addr, err error) {
+	var (
+		sotype int
+		sa     unix.Sockaddr
+	)
+	if sotype, err = unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE); err != nil {
+		err = os.NewSyscallError("getsockopt", err)
+		return
+	}
+	if sa, err = unix.Getsockname(fd); err != nil {
+		err = os.NewSyscallError("getsockname", err)
+		return
+	}
+
+	switch sa.(type) {
+	case *unix.SockaddrInet4, *unix.SockaddrInet6:
+		switch sotype {
+		case unix.SOCK_STREAM:
+			return "tcp", SockaddrToTCPOrUnixAddr(sa), nil
+		case unix.SOCK_DGRAM:
+			return "udp", SockaddrToUDPAddr(sa), nil
+		}
+	case *unix.SockaddrUnix:
+		switch sotype {
+		case unix.SOCK_STREAM:
+			return "unix", SockaddrToTCPOrUnixAddr(sa), nil
+		case unix.SOCK_DGRAM:
+			return "unixgram", &net.UnixAddr{Name: sa.(*unix.SockaddrUnix).Name, Net: "unixgram"}, nil
+		case unix.SOCK_SEQPACKET:
+			return "unixpacket", &net.UnixAddr{Name: sa.(*unix.SockaddrUnix).Name, Net: "unixpacket"}, nil
+		}
+	}
+
+	return "", nil, errors.ErrUnsupportedProtocol
+}
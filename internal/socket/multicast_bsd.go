@@ -0,0 +1,30 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build freebsd || dragonfly || darwin
+// +build freebsd dragonfly darwin
+
+package socket
+
+import "golang.org/x/sys/unix"
+
+// The IPv4 multicast options take u_char on BSD.
+func setIPv4MulticastOpt(fd, opt, value int) error {
+	return unix.SetsockoptByte(fd, unix.IPPROTO_IP, opt, byte(value))
+}
+
+// SetMulticastAll is a no-op on BSD where the sockets only receive the datagrams of their own multicast groups.
+func SetMulticastAll(_ int, _ bool, _ int) error {
+	return nil
+}
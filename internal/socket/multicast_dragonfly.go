@@ -0,0 +1,29 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build dragonfly
+// +build dragonfly
+
+package socket
+
+import (
+	"net"
+
+	"github.com/panjf2000/gnet/v2/pkg/errors"
+)
+
+// setSourceGroup is not supported on DragonFly BSD which lacks source-specific multicast.
+func setSourceGroup(_ int, _ bool, _ int, _, _ net.IP) error {
+	return errors.ErrUnsupportedOp
+}
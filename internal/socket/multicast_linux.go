@@ -0,0 +1,54 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"net"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// groupReqOffset is the offset of the first sockaddr_storage in struct group_source_req,
+// which is aligned to unsigned long.
+const groupReqOffset = int(unsafe.Sizeof(uintptr(0)))
+
+// putSockaddr puts the IP address into the sockaddr_in/sockaddr_in6 at the beginning of b.
+func putSockaddr(b []byte, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		*(*uint16)(unsafe.Pointer(&b[0])) = unix.AF_INET
+		copy(b[4:8], ip4)
+		return
+	}
+	*(*uint16)(unsafe.Pointer(&b[0])) = unix.AF_INET6
+	copy(b[8:24], ip.To16())
+}
+
+func setIPv4MulticastOpt(fd, opt, value int) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_IP, opt, value)
+}
+
+// SetMulticastAll controls whether the socket receives the datagrams of all the multicast groups
+// joined on the system (IP_MULTICAST_ALL) or only the ones joined by itself.
+func SetMulticastAll(fd int, ipv6 bool, all int) error {
+	if ipv6 {
+		return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_ALL, all))
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_ALL, all))
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || darwin
+// +build linux freebsd darwin
+
+package socket
+
+import (
+	"net"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sizeofSockaddrStorage is the size of struct sockaddr_storage.
+const sizeofSockaddrStorage = 128
+
+// setSourceGroup joins/leaves the source-specific multicast group with struct group_source_req,
+// which consists of the interface index and two sockaddr_storage for the group and the source.
+func setSourceGroup(fd int, join bool, ifindex int, group, source net.IP) error {
+	level, opt := unix.IPPROTO_IP, unix.MCAST_JOIN_SOURCE_GROUP
+	if group.To4() == nil {
+		level = unix.IPPROTO_IPV6
+	}
+	if !join {
+		opt = unix.MCAST_LEAVE_SOURCE_GROUP
+	}
+	req := make([]byte, groupReqOffset+2*sizeofSockaddrStorage)
+	*(*uint32)(unsafe.Pointer(&req[0])) = uint32(ifindex)
+	putSockaddr(req[groupReqOffset:], group)
+	putSockaddr(req[groupReqOffset+sizeofSockaddrStorage:], source)
+	return os.NewSyscallError("setsockopt", unix.SetsockoptString(fd, level, opt, string(req)))
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build freebsd || darwin
+// +build freebsd darwin
+
+package socket
+
+import (
+	"net"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// groupReqOffset is the offset of the first sockaddr_storage in struct group_source_req,
+// which is packed to 4 bytes on darwin and aligned to int64 on FreeBSD.
+var groupReqOffset = func() int {
+	if runtime.GOOS == "darwin" {
+		return 4
+	}
+	return int(unsafe.Alignof(int64(0)))
+}()
+
+// putSockaddr puts the IP address into the sockaddr_in/sockaddr_in6 at the beginning of b.
+func putSockaddr(b []byte, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		b[0], b[1] = unix.SizeofSockaddrInet4, unix.AF_INET
+		copy(b[4:8], ip4)
+		return
+	}
+	b[0], b[1] = unix.SizeofSockaddrInet6, unix.AF_INET6
+	copy(b[8:24], ip.To16())
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || darwin
+// +build linux freebsd dragonfly darwin
+
+package socket
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// JoinGroup joins the multicast group on the interface ifi, the system picks the interface if ifi is nil,
+// the socket only receives the datagrams sent by source if it's not nil (source-specific multicast).
+func JoinGroup(fd int, ifi *net.Interface, group, source net.IP) error {
+	return setGroup(fd, true, ifi, group, source)
+}
+
+// LeaveGroup leaves the multicast group joined by JoinGroup with the same arguments.
+func LeaveGroup(fd int, ifi *net.Interface, group, source net.IP) error {
+	return setGroup(fd, false, ifi, group, source)
+}
+
+func setGroup(fd int, join bool, ifi *net.Interface, group, source net.IP) error {
+	var ifindex int
+	if ifi != nil {
+		ifindex = ifi.Index
+	}
+	if source != nil {
+		return setSourceGroup(fd, join, ifindex, group, source)
+	}
+
+	if group4 := group.To4(); group4 != nil {
+		mreq := &unix.IPMreq{}
+		copy(mreq.Multiaddr[:], group4)
+		if ifi != nil {
+			addr, err := interfaceIPv4Addr(ifi)
+			if err != nil {
+				return err
+			}
+			copy(mreq.Interface[:], addr)
+		}
+		opt := unix.IP_ADD_MEMBERSHIP
+		if !join {
+			opt = unix.IP_DROP_MEMBERSHIP
+		}
+		return os.NewSyscallError("setsockopt", unix.SetsockoptIPMreq(fd, unix.IPPROTO_IP, opt, mreq))
+	}
+
+	mreq := &unix.IPv6Mreq{Interface: uint32(ifindex)}
+	copy(mreq.Multiaddr[:], group.To16())
+	opt := unix.IPV6_JOIN_GROUP
+	if !join {
+		opt = unix.IPV6_LEAVE_GROUP
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptIPv6Mreq(fd, unix.IPPROTO_IPV6, opt, mreq))
+}
+
+// SetMulticastInterface sets the interface through which the multicast datagrams are sent.
+func SetMulticastInterface(fd int, ipv6 bool, ifi *net.Interface) error {
+	if ipv6 {
+		return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_IF, ifi.Index))
+	}
+	addr, err := interfaceIPv4Addr(ifi)
+	if err != nil {
+		return err
+	}
+	var a [4]byte
+	copy(a[:], addr)
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInet4Addr(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_IF, a))
+}
+
+// SetMulticastTTL sets the TTL (IPv4) or hop limit (IPv6) of the outgoing multicast datagrams.
+func SetMulticastTTL(fd int, ipv6 bool, ttl int) error {
+	if ipv6 {
+		return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, ttl))
+	}
+	return os.NewSyscallError("setsockopt", setIPv4MulticastOpt(fd, unix.IP_MULTICAST_TTL, ttl))
+}
+
+// SetMulticastLoopback controls whether the outgoing multicast datagrams are looped back to the local sockets.
+func SetMulticastLoopback(fd int, ipv6 bool, loop int) error {
+	if ipv6 {
+		return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_LOOP, loop))
+	}
+	return os.NewSyscallError("setsockopt", setIPv4MulticastOpt(fd, unix.IP_MULTICAST_LOOP, loop))
+}
+
+func interfaceIPv4Addr(ifi *net.Interface) (net.IP, error) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if ip4 := ipNet.IP.To4(); ip4 != nil {
+				return ip4, nil
+			}
+		}
+	}
+	return nil, &net.AddrError{Err: "no IPv4 address on interface", Addr: ifi.Name}
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// GetPeerCred returns the process ID, user ID and group ID of the peer of the unix socket fd
+// with the SO_PEERCRED option, they're the credentials of the peer at the time it called connect(2).
+func GetPeerCred(fd int) (pid int32, uid, gid uint32, err error) {
+	ucred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return 0, 0, 0, os.NewSyscallError("getsockopt", err)
+	}
+	return ucred.Pid, ucred.Uid, ucred.Gid, nil
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build freebsd || dragonfly || darwin
+// +build freebsd dragonfly darwin
+
+package socket
+
+import "github.com/panjf2000/gnet/v2/pkg/errors"
+
+// PktInfoCmsgSpace is zero on BSD where the packet information is not supported.
+var PktInfoCmsgSpace = 0
+
+// SetPktInfo is not supported on BSD.
+func SetPktInfo(_ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+// ParsePktInfo always returns nil on BSD.
+func ParsePktInfo(_ []byte) []byte {
+	return nil
+}
+
+// PutPktInfo is a no-op on BSD.
+func PutPktInfo(_ []byte, _ []byte) int {
+	return 0
+}
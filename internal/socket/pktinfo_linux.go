@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// PktInfoCmsgSpace is the size of the control message carrying the IPv4 or IPv6 packet information.
+var PktInfoCmsgSpace = unix.CmsgSpace(unix.SizeofInet6Pktinfo)
+
+// SetPktInfo enables the IP_PKTINFO (IPv4) or IPV6_RECVPKTINFO (IPv6) option on socket, with which the
+// destination address of each received datagram is delivered in a control message, see ParsePktInfo.
+func SetPktInfo(fd int) error {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return os.NewSyscallError("getsockname", err)
+	}
+	if _, ok := sa.(*unix.SockaddrInet6); ok {
+		return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_RECVPKTINFO, 1))
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_PKTINFO, 1))
+}
+
+// ParsePktInfo returns the local address of the received datagram from the control messages in oob,
+// it's 4 bytes for IPv4 sockets and 16 bytes for IPv6 sockets, or nil if there is no packet information.
+// The returned address aliases oob.
+//
+// For IPv4, it's the local address the kernel would reply from (ipi_spec_dst), which is the destination
+// address of the datagram, or the address of the receiving interface for broadcast and multicast datagrams.
+func ParsePktInfo(oob []byte) []byte {
+	if data := cmsgData(oob, unix.IPPROTO_IP, unix.IP_PKTINFO); len(data) >= unix.SizeofInet4Pktinfo {
+		return (*unix.Inet4Pktinfo)(unsafe.Pointer(&data[0])).Spec_dst[:]
+	}
+	if data := cmsgData(oob, unix.IPPROTO_IPV6, unix.IPV6_PKTINFO); len(data) >= unix.SizeofInet6Pktinfo {
+		return (*unix.Inet6Pktinfo)(unsafe.Pointer(&data[0])).Addr[:]
+	}
+	return nil
+}
+
+// PutPktInfo builds an IP_PKTINFO or IPV6_PKTINFO control message in b for sending a datagram from the
+// source address src returned by ParsePktInfo, it returns the length of the control message.
+func PutPktInfo(b []byte, src []byte) int {
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	if len(src) == 4 {
+		h.Level, h.Type = unix.IPPROTO_IP, unix.IP_PKTINFO
+		h.SetLen(unix.CmsgLen(unix.SizeofInet4Pktinfo))
+		info := (*unix.Inet4Pktinfo)(unsafe.Pointer(&b[unix.CmsgLen(0)]))
+		*info = unix.Inet4Pktinfo{}
+		copy(info.Spec_dst[:], src)
+		return unix.CmsgSpace(unix.SizeofInet4Pktinfo)
+	}
+	h.Level, h.Type = unix.IPPROTO_IPV6, unix.IPV6_PKTINFO
+	h.SetLen(unix.CmsgLen(unix.SizeofInet6Pktinfo))
+	info := (*unix.Inet6Pktinfo)(unsafe.Pointer(&b[unix.CmsgLen(0)]))
+	*info = unix.Inet6Pktinfo{}
+	copy(info.Addr[:], src)
+	return unix.CmsgSpace(unix.SizeofInet6Pktinfo)
+}
@@ -46,3 +46,8 @@ func maxListenerBacklog() int {
 	}
 	return int(n)
 }
+
+// IsAbstractUnixAddr always returns false on BSD where the abstract namespace is not supported.
+func IsAbstractUnixAddr(_ string) bool {
+	return false
+}
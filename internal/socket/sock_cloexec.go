@@ -22,3 +22,8 @@ import "golang.org/x/sys/unix"
 func sysSocket(family, sotype, proto int) (int, error) {
 	return unix.Socket(family, sotype|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, proto)
 }
+
+// Accept accepts a connection on the listener socket fd, the new socket is non-blocking and close-on-exec.
+func Accept(fd int) (int, unix.Sockaddr, error) {
+	return unix.Accept4(fd, unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+}
@@ -55,3 +55,9 @@ func maxListenerBacklog() int {
 
 	return n
 }
+
+// IsAbstractUnixAddr reports whether addr is an address in the abstract namespace of unix sockets,
+// which begins with '@' and has no counterpart on the filesystem.
+func IsAbstractUnixAddr(addr string) bool {
+	return len(addr) > 0 && addr[0] == '@'
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/v2/pkg/errors"
+)
+
+// SockaddrFromRaw converts a RawSockaddrAny of namelen bytes filled in by the kernel, e.g. the msg_name
+// of recvmsg(2), to a Sockaddr, it returns nil if the address family is not supported.
+func SockaddrFromRaw(rsa *unix.RawSockaddrAny, namelen uint32) unix.Sockaddr {
+	switch rsa.Addr.Family {
+	case unix.AF_UNIX:
+		pp := (*unix.RawSockaddrUnix)(unsafe.Pointer(rsa))
+		n := int(namelen) - int(unsafe.Offsetof(pp.Path))
+		if n <= 0 { // unnamed socket
+			return &unix.SockaddrUnix{}
+		}
+		if n > len(pp.Path) {
+			n = len(pp.Path)
+		}
+		path := (*[len(pp.Path)]byte)(unsafe.Pointer(&pp.Path[0]))[:n]
+		if path[0] == 0 {
+			// Abstract socket address, whose name is not terminated by NUL.
+			return &unix.SockaddrUnix{Name: "@" + string(path[1:])}
+		}
+		for i, b := range path {
+			if b == 0 {
+				path = path[:i]
+				break
+			}
+		}
+		return &unix.SockaddrUnix{Name: string(path)}
+	case unix.AF_INET:
+		pp := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		sa := new(unix.SockaddrInet4)
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		sa.Port = int(p[0])<<8 + int(p[1])
+		sa.Addr = pp.Addr
+		return sa
+	case unix.AF_INET6:
+		pp := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		sa := new(unix.SockaddrInet6)
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		sa.Port = int(p[0])<<8 + int(p[1])
+		sa.ZoneId = pp.Scope_id
+		sa.Addr = pp.Addr
+		return sa
+	}
+	return nil
+}
+
+// SockaddrToRaw fills in rsa with sa, e.g. for the msg_name of sendmsg(2),
+// it returns the length of the raw socket address.
+func SockaddrToRaw(sa unix.Sockaddr, rsa *unix.RawSockaddrAny) (uint32, error) {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		pp := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		pp.Family = unix.AF_INET
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		pp.Addr = sa.Addr
+		return unix.SizeofSockaddrInet4, nil
+	case *unix.SockaddrInet6:
+		pp := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		pp.Family = unix.AF_INET6
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		pp.Flowinfo = 0
+		pp.Scope_id = sa.ZoneId
+		pp.Addr = sa.Addr
+		return unix.SizeofSockaddrInet6, nil
+	case *unix.SockaddrUnix:
+		pp := (*unix.RawSockaddrUnix)(unsafe.Pointer(rsa))
+		name := sa.Name
+		if len(name) >= len(pp.Path) {
+			return 0, unix.EINVAL
+		}
+		pp.Family = unix.AF_UNIX
+		for i := 0; i < len(name); i++ {
+			pp.Path[i] = int8(name[i])
+		}
+		// The pathname is terminated by NUL, while the abstract name led by '@' isn't.
+		namelen := uint32(unsafe.Offsetof(pp.Path))
+		if len(name) > 0 {
+			namelen += uint32(len(name)) + 1
+			pp.Path[len(name)] = 0
+		}
+		if name != "" && name[0] == '@' {
+			pp.Path[0] = 0
+			namelen--
+		}
+		return namelen, nil
+	}
+	return 0, errors.ErrUnsupportedProtocol
+}
+
+// SockaddrEqual reports whether a and b refer to the same address, two nil addresses are equal.
+func SockaddrEqual(a, b unix.Sockaddr) bool {
+	switch a := a.(type) {
+	case nil:
+		return b == nil
+	case *unix.SockaddrInet4:
+		b, ok := b.(*unix.SockaddrInet4)
+		return ok && a.Port == b.Port && a.Addr == b.Addr
+	case *unix.SockaddrInet6:
+		b, ok := b.(*unix.SockaddrInet6)
+		return ok && a.Port == b.Port && a.ZoneId == b.ZoneId && a.Addr == b.Addr
+	case *unix.SockaddrUnix:
+		b, ok := b.(*unix.SockaddrUnix)
+		return ok && a.Name == b.Name
+	}
+	return false
+}
@@ -32,7 +32,12 @@ type Option struct {
 
 // TCPSocket calls the internal tcpSocket.
 func TCPSocket(proto, addr string, passive bool, sockOpts ...Option) (int, net.Addr, error) {
-	return tcpSocket(proto, addr, passive, sockOpts...)
+	return tcpSocket(proto, addr, passive, 0, sockOpts...)
+}
+
+// TCPListenerSocket calls the internal tcpSocket with the backlog of the listener.
+func TCPListenerSocket(proto, addr string, backlog int, sockOpts ...Option) (int, net.Addr, error) {
+	return tcpSocket(proto, addr, true, backlog, sockOpts...)
 }
 
 // UDPSocket calls the internal udpSocket.
@@ -42,5 +47,10 @@ func UDPSocket(proto, addr string, connect bool, sockOpts ...Option) (int, net.A
 
 // UnixSocket calls the internal udsSocket.
 func UnixSocket(proto, addr string, passive bool, sockOpts ...Option) (int, net.Addr, error) {
-	return udsSocket(proto, addr, passive, sockOpts...)
+	return udsSocket(proto, addr, passive, nil, 0, sockOpts...)
+}
+
+// UnixListenerSocket calls the internal udsSocket with the permissions of the socket file and the backlog of the listener.
+func UnixListenerSocket(proto, addr string, perm *UnixPerm, backlog int, sockOpts ...Option) (int, net.Addr, error) {
+	return udsSocket(proto, addr, true, perm, backlog, sockOpts...)
 }
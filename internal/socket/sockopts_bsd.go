@@ -0,0 +1,60 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build freebsd || dragonfly || darwin
+// +build freebsd dragonfly darwin
+
+package socket
+
+import "github.com/panjf2000/gnet/v2/pkg/errors"
+
+// SetUserTimeout is not supported on BSD.
+func SetUserTimeout(_, _ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+// SetQuickAck is not supported on BSD.
+func SetQuickAck(_, _ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+// SetNotSentLowat is not supported on BSD.
+func SetNotSentLowat(_, _ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+// SetCongestion is not supported on BSD.
+func SetCongestion(_ int, _ string) error {
+	return errors.ErrUnsupportedOp
+}
+
+// SetFastOpen is not supported on BSD.
+func SetFastOpen(_, _ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+// SetFastOpenConnect is not supported on BSD.
+func SetFastOpenConnect(_, _ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+// SetDeferAccept is not supported on BSD.
+func SetDeferAccept(_, _ int) error {
+	return errors.ErrUnsupportedOp
+}
+
+// SetMark is not supported on BSD.
+func SetMark(_, _ int) error {
+	return errors.ErrUnsupportedOp
+}
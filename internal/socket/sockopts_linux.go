@@ -0,0 +1,68 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socket
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetUserTimeout sets the maximum amount of time in milliseconds that the transmitted data
+// may remain unacknowledged before the connection is closed forcibly (TCP_USER_TIMEOUT).
+func SetUserTimeout(fd, msecs int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, msecs))
+}
+
+// SetQuickAck enables/disables the quick ACK mode (TCP_QUICKACK), note that the kernel may leave
+// the quick ACK mode afterwards, in which case it needs to be set again.
+func SetQuickAck(fd, quickAck int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_QUICKACK, quickAck))
+}
+
+// SetNotSentLowat sets the threshold of the unsent bytes in the socket send buffer
+// above which the socket is no longer reported as writable (TCP_NOTSENT_LOWAT).
+func SetNotSentLowat(fd, bytes int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT, bytes))
+}
+
+// SetCongestion sets the congestion control algorithm of the socket (TCP_CONGESTION).
+func SetCongestion(fd int, name string) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptString(fd, unix.IPPROTO_TCP, unix.TCP_CONGESTION, name))
+}
+
+// SetFastOpen enables TCP Fast Open on the listener socket with the maximum length
+// of the queue of the pending TFO requests (TCP_FASTOPEN).
+func SetFastOpen(fd, qlen int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN, qlen))
+}
+
+// SetFastOpenConnect enables TCP Fast Open on the client socket before it connects (TCP_FASTOPEN_CONNECT),
+// the connect call returns right away and the SYN is sent along with the data of the first write.
+func SetFastOpenConnect(fd, fastOpen int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, fastOpen))
+}
+
+// SetDeferAccept makes the listener socket wake up the acceptor only when the data arrives on the new
+// connection, or after the timeout in seconds (TCP_DEFER_ACCEPT).
+func SetDeferAccept(fd, secs int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT, secs))
+}
+
+// SetMark sets the mark of the packets sent on the socket (SO_MARK) for the policy routing and filtering,
+// it requires the CAP_NET_ADMIN capability.
+func SetMark(fd, mark int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, mark))
+}
@@ -58,3 +58,48 @@ func SetReuseAddr(fd, reuseAddr int) error {
 func SetIPv6Only(fd, ipv6only int) error {
 	return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, ipv6only)
 }
+
+// SetKeepAliveInterval sets the interval between the keep-alive probes in seconds.
+func SetKeepAliveInterval(fd, secs int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, secs))
+}
+
+// SetKeepAliveCount sets the number of unacknowledged keep-alive probes
+// before the connection is considered dead.
+func SetKeepAliveCount(fd, count int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPCNT, count))
+}
+
+// SetLinger sets the behavior of close on a socket which still has data waiting to be sent.
+//
+// If secs < 0 (the default), the operating system finishes sending the data in the background.
+// If secs == 0, the operating system discards any unsent data and resets the connection.
+// If secs > 0, the operating system sends the data in the background, some of the remaining
+// data may be discarded after secs have elapsed.
+func SetLinger(fd, secs int) error {
+	var l unix.Linger
+	if secs >= 0 {
+		l.Onoff = 1
+		l.Linger = int32(secs)
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER, &l))
+}
+
+// SetTOS sets the type-of-service field of the IPv4 packets (IP_TOS) and/or
+// the traffic class of the IPv6 packets (IPV6_TCLASS) sent on the socket.
+func SetTOS(fd, tos int) error {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return os.NewSyscallError("getsockname", err)
+	}
+	if _, ok := sa.(*unix.SockaddrInet6); ok {
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+		// The IPv4-mapped traffic on a dual-stack socket goes by IP_TOS,
+		// which may not be accepted by the IPv6 sockets on some platforms.
+		_ = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, tos)
+		return nil
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, tos))
+}
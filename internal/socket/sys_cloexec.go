@@ -18,6 +18,7 @@
 package socket
 
 import (
+	"os"
 	"syscall"
 
 	"golang.org/x/sys/unix"
@@ -40,3 +41,22 @@ func sysSocket(family, sotype, proto int) (fd int, err error) {
 
 	return
 }
+
+// Accept accepts a connection on the listener socket fd, the new socket is non-blocking and close-on-exec.
+func Accept(fd int) (nfd int, sa unix.Sockaddr, err error) {
+	syscall.ForkLock.RLock()
+	if nfd, sa, err = unix.Accept(fd); err == nil {
+		unix.CloseOnExec(nfd)
+	}
+	syscall.ForkLock.RUnlock()
+
+	if err != nil {
+		return
+	}
+
+	if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(nfd, true)); err != nil {
+		_ = unix.Close(nfd)
+	}
+
+	return
+}
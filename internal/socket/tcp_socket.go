@@ -105,9 +105,18 @@ func determineTCPProto(proto string, addr *net.TCPAddr) (string, error) {
 	return "", errors.ErrUnsupportedTCPProtocol
 }
 
+// listenBacklog returns the backlog of the listener sockets, it's the maximum if backlog is not greater than zero
+// or greater than the maximum.
+func listenBacklog(backlog int) int {
+	if backlog <= 0 || backlog > listenerBacklogMaxSize {
+		return listenerBacklogMaxSize
+	}
+	return backlog
+}
+
 // tcpSocket creates an endpoint for communication and returns a file descriptor that refers to that endpoint.
 // Argument `reusePort` indicates whether the SO_REUSEPORT flag will be assigned.
-func tcpSocket(proto, addr string, passive bool, sockOpts ...Option) (fd int, netAddr net.Addr, err error) {
+func tcpSocket(proto, addr string, passive bool, backlog int, sockOpts ...Option) (fd int, netAddr net.Addr, err error) {
 	var (
 		family   int
 		ipv6only bool
@@ -145,8 +154,7 @@ func tcpSocket(proto, addr string, passive bool, sockOpts ...Option) (fd int, ne
 	}
 
 	if passive {
-		// Set backlog size to the maximum.
-		err = os.NewSyscallError("listen", unix.Listen(fd, listenerBacklogMaxSize))
+		err = os.NewSyscallError("listen", unix.Listen(fd, listenBacklog(backlog)))
 	} else {
 		err = os.NewSyscallError("connect", unix.Connect(fd, sa))
 	}
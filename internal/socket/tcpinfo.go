@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || darwin
+// +build linux freebsd dragonfly darwin
+
+package socket
+
+// TCPInfo mirrors struct tcp_info of Linux, which is retrieved by the TCP_INFO socket option,
+// the fields that are not provided by the running kernel are left zero.
+type TCPInfo struct {
+	State         uint8
+	CaState       uint8
+	Retransmits   uint8
+	Probes        uint8
+	Backoff       uint8
+	Options       uint8
+	Wscale        uint8
+	Flags         uint8
+	Rto           uint32
+	Ato           uint32
+	SndMss        uint32
+	RcvMss        uint32
+	Unacked       uint32
+	Sacked        uint32
+	Lost          uint32
+	Retrans       uint32
+	Fackets       uint32
+	LastDataSent  uint32
+	LastAckSent   uint32
+	LastDataRecv  uint32
+	LastAckRecv   uint32
+	Pmtu          uint32
+	RcvSsthresh   uint32
+	Rtt           uint32
+	Rttvar        uint32
+	SndSsthresh   uint32
+	SndCwnd       uint32
+	Advmss        uint32
+	Reordering    uint32
+	RcvRtt        uint32
+	RcvSpace      uint32
+	TotalRetrans  uint32
+	PacingRate    uint64
+	MaxPacingRate uint64
+	BytesAcked    uint64
+	BytesReceived uint64
+	SegsOut       uint32
+	SegsIn        uint32
+	NotsentBytes  uint32
+	MinRtt        uint32
+	DataSegsIn    uint32
+	DataSegsOut   uint32
+	DeliveryRate  uint64
+	BusyTime      uint64
+	RwndLimited   uint64
+	SndbufLimited uint64
+	Delivered     uint32
+	DeliveredCe   uint32
+	BytesSent     uint64
+	BytesRetrans  uint64
+	DsackDups     uint32
+	ReordSeen     uint32
+	RcvOoopack    uint32
+	SndWnd        uint32
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The socket options of UDP GSO/GRO, see linux/udp.h.
+const (
+	udpSegment = 103 // UDP_SEGMENT
+	udpGRO     = 104 // UDP_GRO
+)
+
+// UDPSegmentCmsgSpace is the size of the control message built by PutUDPSegment.
+var UDPSegmentCmsgSpace = unix.CmsgSpace(2)
+
+// UDPGROCmsgSpace is the size of the control message carrying the segment size of UDP GRO.
+var UDPGROCmsgSpace = unix.CmsgSpace(4)
+
+// SetUDPGRO enables/disables the UDP_GRO option on socket, with which the kernel coalesces
+// the datagrams from the same peer into one buffer, see UDPGROSegmentSize.
+func SetUDPGRO(fd, gro int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.IPPROTO_UDP, udpGRO, gro))
+}
+
+// PutUDPSegment builds a UDP_SEGMENT control message in b for sending a buffer that consists of
+// multiple datagrams of size bytes with UDP GSO, it returns the length of the control message.
+func PutUDPSegment(b []byte, size uint16) int {
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = unix.IPPROTO_UDP
+	h.Type = udpSegment
+	h.SetLen(unix.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&b[unix.CmsgLen(0)])) = size
+	return unix.CmsgSpace(2)
+}
+
+// UDPGROSegmentSize returns the size of the datagrams coalesced by UDP GRO from the control messages in oob,
+// or 0 if the received buffer is a single datagram.
+func UDPGROSegmentSize(oob []byte) int {
+	if data := cmsgData(oob, unix.IPPROTO_UDP, udpGRO); len(data) >= 4 {
+		return int(*(*int32)(unsafe.Pointer(&data[0])))
+	}
+	return 0
+}
+
+// cmsgData returns the data of the first control message in oob with the given level and type.
+func cmsgData(oob []byte, level, typ int32) []byte {
+	for len(oob) >= unix.SizeofCmsghdr {
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+		n := int(h.Len)
+		if n < unix.CmsgLen(0) || n > len(oob) {
+			return nil
+		}
+		if h.Level == level && h.Type == typ {
+			return oob[unix.CmsgLen(0):n]
+		}
+		if next := unix.CmsgSpace(n - unix.CmsgLen(0)); next < len(oob) {
+			oob = oob[next:]
+		} else {
+			break
+		}
+	}
+	return nil
+}
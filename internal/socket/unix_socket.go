@@ -34,7 +34,7 @@ func GetUnixSockAddr(proto, addr string) (sa unix.Sockaddr, family int, unixAddr
 	}
 
 	switch unixAddr.Network() {
-	case "unix":
+	case "unix", "unixgram", "unixpacket":
 		sa, family = &unix.SockaddrUnix{Name: unixAddr.Name}, unix.AF_UNIX
 	default:
 		err = errors.ErrUnsupportedUDSProtocol
@@ -43,9 +43,31 @@ func GetUnixSockAddr(proto, addr string) (sa unix.Sockaddr, family int, unixAddr
 	return
 }
 
+// UnixPerm is the file mode and the ownership of the socket file created by a unix listener.
+type UnixPerm struct {
+	Mode os.FileMode // file mode, it's left unchanged if it's zero
+	UID  int         // owner of the socket file, it's left unchanged if it's -1
+	GID  int         // group of the socket file, it's left unchanged if it's -1
+}
+
+func (p *UnixPerm) apply(path string) error {
+	if p.UID != -1 || p.GID != -1 {
+		if err := os.Lchown(path, p.UID, p.GID); err != nil {
+			return err
+		}
+	}
+	if p.Mode != 0 {
+		return os.Chmod(path, p.Mode)
+	}
+	return nil
+}
+
 // udsSocket creates an endpoint for communication and returns a file descriptor that refers to that endpoint.
 // Argument `reusePort` indicates whether the SO_REUSEPORT flag will be assigned.
-func udsSocket(proto, addr string, passive bool, sockOpts ...Option) (fd int, netAddr net.Addr, err error) {
+//
+// The permissions of the socket file are applied between bind(2) and listen(2), so no client is able to connect
+// to the listener before they're in place, note that the datagrams can be sent to a unixgram socket once it's bound.
+func udsSocket(proto, addr string, passive bool, perm *UnixPerm, backlog int, sockOpts ...Option) (fd int, netAddr net.Addr, err error) {
 	var (
 		family int
 		sa     unix.Sockaddr
@@ -55,7 +77,14 @@ func udsSocket(proto, addr string, passive bool, sockOpts ...Option) (fd int, ne
 		return
 	}
 
-	if fd, err = sysSocket(family, unix.SOCK_STREAM, 0); err != nil {
+	sotype := unix.SOCK_STREAM
+	switch proto {
+	case "unixgram":
+		sotype = unix.SOCK_DGRAM
+	case "unixpacket":
+		sotype = unix.SOCK_SEQPACKET
+	}
+	if fd, err = sysSocket(family, sotype, 0); err != nil {
 		err = os.NewSyscallError("socket", err)
 		return
 	}
@@ -71,16 +100,36 @@ func udsSocket(proto, addr string, passive bool, sockOpts ...Option) (fd int, ne
 		}
 	}
 
-	if err = os.NewSyscallError("bind", unix.Bind(fd, sa)); err != nil {
+	if passive {
+		if err = os.NewSyscallError("bind", unix.Bind(fd, sa)); err != nil {
+			return
+		}
+		if perm != nil && !IsAbstractUnixAddr(addr) {
+			if err = perm.apply(addr); err != nil {
+				_ = os.Remove(addr)
+				return
+			}
+		}
+		// The datagram socket receives datagrams once it's bound.
+		if sotype != unix.SOCK_DGRAM {
+			err = os.NewSyscallError("listen", unix.Listen(fd, listenBacklog(backlog)))
+		}
 		return
 	}
 
-	if passive {
-		// Set backlog size to the maximum.
-		err = os.NewSyscallError("listen", unix.Listen(fd, listenerBacklogMaxSize))
-	} else {
-		err = os.NewSyscallError("connect", unix.Connect(fd, sa))
+	if sotype == unix.SOCK_DGRAM {
+		// Bind the socket to an autogenerated abstract address (Linux only) so that the peer is able to
+		// reply to it, the socket remains unnamed on the other systems.
+		_ = unix.Bind(fd, &unix.SockaddrUnix{})
 	}
-
+	if err = os.NewSyscallError("connect", unix.Connect(fd, sa)); err != nil {
+		return
+	}
+	// Return the local address for the client socket.
+	if sa, err = unix.Getsockname(fd); err != nil {
+		err = os.NewSyscallError("getsockname", err)
+		return
+	}
+	netAddr = &net.UnixAddr{Name: sa.(*unix.SockaddrUnix).Name, Net: proto}
 	return
 }
@@ -20,6 +20,8 @@ package gnet
 import (
 	"net"
 	"os"
+	"os/user"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -32,11 +34,23 @@ import (
 )
 
 type listener struct {
-	once             sync.Once
+	mu               sync.Mutex // guards the socket against being closed while it's being used by other goroutines
+	closed           bool       // listener has been closed
+	id               int        // index of the listener in the protocol addresses of the engine
 	fd               int
 	addr             net.Addr
 	address, network string
 	sockOpts         []socket.Option
+	perm             *socket.UnixPerm        // permissions of the socket file of unix listener, nil if they're not set
+	backlog          int                     // backlog of the stream listener, the maximum if it's not greater than zero
+	transferred      bool                    // listener has been passed on to another process
+	cloned           bool                    // listener shares the socket of another listener which owns the socket file
+	activated        bool                    // listener is passed by the service manager via socket activation
+	inherited        bool                    // listener is passed on by the parent process during a graceful restart
+	adopted          bool                    // listener is adopted from a net.Listener
+	multicast        bool                    // listener is bound to a multicast address
+	oob              []byte                  // buffer for the packet information of UDP listeners, nil if it's disabled
+	localAddrs       map[string]*net.UDPAddr // local addresses of the datagrams received on the UDP listener: IP -> address
 	pollAttachment   *netpoll.PollAttachment // listener attachment for poller
 }
 
@@ -46,41 +60,189 @@ func (ln *listener) packPollAttachment(handler netpoll.PollEventHandler) *netpol
 }
 
 func (ln *listener) dup() (int, string, error) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	if ln.closed {
+		return -1, "dup", errors.ErrListenerClosed
+	}
 	return netpoll.Dup(ln.fd)
 }
 
+// transfer marks the listener as passed on to another process, so that its socket file won't be removed.
+func (ln *listener) transfer() {
+	ln.mu.Lock()
+	ln.transferred = true
+	ln.mu.Unlock()
+}
+
+// control invokes f on the socket of the listener unless the listener has been closed.
+func (ln *listener) control(f func(fd int) error) error {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	if ln.closed {
+		return errors.ErrListenerClosed
+	}
+	return f(ln.fd)
+}
+
 func (ln *listener) normalize() (err error) {
 	switch ln.network {
 	case "tcp", "tcp4", "tcp6":
-		ln.fd, ln.addr, err = socket.TCPSocket(ln.network, ln.address, true, ln.sockOpts...)
+		ln.fd, ln.addr, err = socket.TCPListenerSocket(ln.network, ln.address, ln.backlog, ln.sockOpts...)
 		ln.network = "tcp"
 	case "udp", "udp4", "udp6":
 		ln.fd, ln.addr, err = socket.UDPSocket(ln.network, ln.address, false, ln.sockOpts...)
 		ln.network = "udp"
-	case "unix":
-		_ = os.RemoveAll(ln.address)
-		ln.fd, ln.addr, err = socket.UnixSocket(ln.network, ln.address, true, ln.sockOpts...)
+	case "unix", "unixgram", "unixpacket":
+		if !socket.IsAbstractUnixAddr(ln.address) {
+			_ = os.RemoveAll(ln.address)
+		}
+		ln.fd, ln.addr, err = socket.UnixListenerSocket(ln.network, ln.address, ln.perm, ln.backlog, ln.sockOpts...)
 	default:
 		err = errors.ErrUnsupportedProtocol
 	}
 	return
 }
 
+// remoteAddr returns the address of the peer of the connection accepted by the listener.
+func (ln *listener) remoteAddr(sa unix.Sockaddr) net.Addr {
+	addr := socket.SockaddrToTCPOrUnixAddr(sa)
+	if ua, ok := addr.(*net.UnixAddr); ok {
+		ua.Net = ln.network
+	}
+	return addr
+}
+
+// isDatagram reports whether the listener receives datagrams instead of accepting connections.
+func (ln *listener) isDatagram() bool {
+	return ln.network == "udp" || ln.network == "unixgram"
+}
+
 func (ln *listener) close() {
-	ln.once.Do(
-		func() {
-			if ln.fd > 0 {
-				logging.Error(os.NewSyscallError("close", unix.Close(ln.fd)))
-			}
-			if ln.network == "unix" {
-				logging.Error(os.RemoveAll(ln.address))
-			}
-		})
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	if ln.closed {
+		return
+	}
+	ln.closed = true
+	if ln.fd > 0 {
+		logging.Error(os.NewSyscallError("close", unix.Close(ln.fd)))
+	}
+	if strings.HasPrefix(ln.network, "unix") && !ln.transferred && !ln.activated && !ln.cloned &&
+		!socket.IsAbstractUnixAddr(ln.address) {
+		logging.Error(os.RemoveAll(ln.address))
+	}
+}
+
+// newListenerFromFd makes up a listener with a pre-opened socket, the network of the listener
+// is determined by probing the socket. The socket is left open on failure, it's up to the caller
+// to close it if the socket is owned by the engine.
+func newListenerFromFd(fd int, address string) (ln *listener, err error) {
+	ln = &listener{fd: fd, address: address}
+	if ln.network, ln.addr, err = socket.ProbeSocket(fd); err != nil {
+		return nil, err
+	}
+	if err = os.NewSyscallError("fcntl nonblock", unix.SetNonblock(fd, true)); err != nil {
+		return nil, err
+	}
+	unix.CloseOnExec(fd)
+	return
+}
+
+// adoptListener makes up a listener with a duplicate of the socket underlying ln and closes ln.
+func adoptListener(ln net.Listener) (l *listener, err error) {
+	if ul, ok := ln.(*net.UnixListener); ok {
+		// The socket file is taken over by the listener and removed in listener.close.
+		ul.SetUnlinkOnClose(false)
+	}
+	fd, err := dupSyscallConn(ln)
+	if err != nil {
+		return
+	}
+	_ = ln.Close()
+	if l, err = newListenerFromFd(fd, ln.Addr().String()); err != nil {
+		_ = unix.Close(fd)
+		return
+	}
+	l.adopted = true
+	return
+}
+
+// enablePktInfo makes the UDP listener bound to a wildcard address receive the local address of each datagram,
+// which is used as the source address of the replies to make sure they leave from the address the peers sent to.
+func (ln *listener) enablePktInfo() error {
+	if addr, ok := ln.addr.(*net.UDPAddr); !ok || ln.multicast || (addr.IP != nil && !addr.IP.IsUnspecified()) {
+		return nil
+	}
+	if err := socket.SetPktInfo(ln.fd); err != nil {
+		return err
+	}
+	ln.oob = make([]byte, socket.PktInfoCmsgSpace)
+	return nil
+}
+
+// udpLocalAddr returns the local address of the datagram received on the UDP listener at local IP address ip,
+// which is taken from the packet information. The addresses are cached by the listener since there are only
+// a few local IP addresses, and each listener is only used by one event-loop.
+func (ln *listener) udpLocalAddr(ip []byte) net.Addr {
+	if ip == nil || net.IP(ip).IsMulticast() {
+		return ln.addr
+	}
+	if addr, ok := ln.localAddrs[string(ip)]; ok {
+		return addr
+	}
+	if ln.localAddrs == nil {
+		ln.localAddrs = make(map[string]*net.UDPAddr)
+	}
+	addr := &net.UDPAddr{IP: append(net.IP(nil), ip...), Port: ln.addr.(*net.UDPAddr).Port}
+	ln.localAddrs[string(ip)] = addr
+	return addr
+}
+
+// clone makes up a listener sharing the same socket for another event-loop.
+func (ln *listener) clone() (*listener, error) {
+	fd, sc, err := ln.dup()
+	if err != nil {
+		return nil, os.NewSyscallError(sc, err)
+	}
+	return &listener{
+		id:        ln.id,
+		fd:        fd,
+		addr:      ln.addr,
+		address:   ln.address,
+		network:   ln.network,
+		activated: ln.activated,
+		adopted:   ln.adopted,
+		multicast: ln.multicast,
+		cloned:    true,
+	}, nil
 }
 
 func initListener(network, addr string, options *Options) (l *listener, err error) {
+	if fd, ok := takeInheritedFd(network, addr); ok {
+		if l, err = newListenerFromFd(fd, addr); err != nil {
+			_ = unix.Close(fd)
+			return
+		}
+		l.activated = network == "fd"
+		l.inherited = true
+		return
+	}
+	if network == "fd" {
+		var fd int
+		if fd, err = activatedFd(addr); err != nil {
+			return
+		}
+		// The activated sockets are owned by the service manager, leave them open if they can't be used.
+		if l, err = newListenerFromFd(fd, addr); err == nil {
+			l.activated = true
+		}
+		return
+	}
+
 	var sockOpts []socket.Option
-	if options.ReusePort || strings.HasPrefix(network, "udp") {
+	// The unix sockets are shared among event-loops instead of being bound more than once, see engine.listenerFor.
+	if (options.ReusePort && !strings.HasPrefix(network, "unix")) || strings.HasPrefix(network, "udp") {
 		sockOpt := socket.Option{SetSockOpt: socket.SetReuseport, Opt: 1}
 		sockOpts = append(sockOpts, sockOpt)
 	}
@@ -92,6 +254,19 @@ func initListener(network, addr string, options *Options) (l *listener, err erro
 		sockOpt := socket.Option{SetSockOpt: socket.SetNoDelay, Opt: 1}
 		sockOpts = append(sockOpts, sockOpt)
 	}
+	if strings.HasPrefix(network, "tcp") {
+		sockOpts = append(sockOpts, tcpSockOpts(options)...)
+		if options.TCPFastOpen > 0 {
+			setFastOpen := optionalSockOpt(options.Logger, "TCP_FASTOPEN", socket.SetFastOpen)
+			sockOpt := socket.Option{SetSockOpt: setFastOpen, Opt: options.TCPFastOpen}
+			sockOpts = append(sockOpts, sockOpt)
+		}
+		if options.TCPDeferAccept > 0 {
+			setDeferAccept := optionalSockOpt(options.Logger, "TCP_DEFER_ACCEPT", socket.SetDeferAccept)
+			sockOpt := socket.Option{SetSockOpt: setDeferAccept, Opt: seconds(options.TCPDeferAccept)}
+			sockOpts = append(sockOpts, sockOpt)
+		}
+	}
 	if options.SocketRecvBuffer > 0 {
 		sockOpt := socket.Option{SetSockOpt: socket.SetRecvBuffer, Opt: options.SocketRecvBuffer}
 		sockOpts = append(sockOpts, sockOpt)
@@ -100,7 +275,58 @@ func initListener(network, addr string, options *Options) (l *listener, err erro
 		sockOpt := socket.Option{SetSockOpt: socket.SetSendBuffer, Opt: options.SocketSendBuffer}
 		sockOpts = append(sockOpts, sockOpt)
 	}
-	l = &listener{network: network, address: addr, sockOpts: sockOpts}
-	err = l.normalize()
+	// The callbacks go last so that they're able to override the other socket options.
+	for _, f := range options.SocketOptions {
+		control := f
+		sockOpt := socket.Option{SetSockOpt: func(fd, _ int) error { return control(fd) }}
+		sockOpts = append(sockOpts, sockOpt)
+	}
+	l = &listener{network: network, address: addr, sockOpts: sockOpts, backlog: options.ListenBacklog}
+	if strings.HasPrefix(network, "unix") {
+		if l.perm, err = unixPerm(options); err != nil {
+			return
+		}
+	}
+	if err = l.normalize(); err != nil {
+		return
+	}
+	if ua, ok := l.addr.(*net.UDPAddr); ok && ua.IP.IsMulticast() {
+		l.multicast = true
+		if err = l.joinMulticast(options); err != nil {
+			l.close()
+		}
+	}
+	return
+}
+
+// unixPerm resolves the permissions of the socket files of unix listeners from options,
+// the owner and the group are either names or numeric IDs. It returns nil if none of them is set.
+func unixPerm(options *Options) (perm *socket.UnixPerm, err error) {
+	if options.UnixSocketMode == 0 && options.UnixSocketOwner == "" && options.UnixSocketGroup == "" {
+		return
+	}
+	perm = &socket.UnixPerm{Mode: options.UnixSocketMode, UID: -1, GID: -1}
+	if owner := options.UnixSocketOwner; owner != "" {
+		if perm.UID, err = strconv.Atoi(owner); err != nil {
+			var u *user.User
+			if u, err = user.Lookup(owner); err != nil {
+				return nil, err
+			}
+			if perm.UID, err = strconv.Atoi(u.Uid); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if group := options.UnixSocketGroup; group != "" {
+		if perm.GID, err = strconv.Atoi(group); err != nil {
+			var g *user.Group
+			if g, err = user.LookupGroup(group); err != nil {
+				return nil, err
+			}
+			if perm.GID, err = strconv.Atoi(g.Gid); err != nil {
+				return nil, err
+			}
+		}
+	}
 	return
 }
@@ -17,6 +17,7 @@ package gnet
 import (
 	"hash/crc32"
 	"net"
+	"sync/atomic"
 
 	"github.com/panjf2000/gnet/v2/internal/toolkit"
 )
@@ -47,7 +48,7 @@ type (
 
 	// roundRobinLoadBalancer with Round-Robin algorithm.
 	roundRobinLoadBalancer struct {
-		nextLoopIndex int
+		nextLoopIndex uint32 // accessed atomically since Engine.Enroll may pick event-loops concurrently
 		eventLoops    []*eventloop
 		size          int
 	}
@@ -75,11 +76,8 @@ func (lb *roundRobinLoadBalancer) register(el *eventloop) {
 
 // next returns the eligible event-loop based on Round-Robin algorithm.
 func (lb *roundRobinLoadBalancer) next(_ net.Addr) (el *eventloop) {
-	el = lb.eventLoops[lb.nextLoopIndex]
-	if lb.nextLoopIndex++; lb.nextLoopIndex >= lb.size {
-		lb.nextLoopIndex = 0
-	}
-	return
+	idx := atomic.AddUint32(&lb.nextLoopIndex, 1) - 1
+	return lb.eventLoops[int(idx%uint32(lb.size))]
 }
 
 func (lb *roundRobinLoadBalancer) iterate(f func(int, *eventloop) bool) {
@@ -0,0 +1,119 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || darwin
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"net"
+
+	"github.com/panjf2000/gnet/v2/internal/socket"
+	"github.com/panjf2000/gnet/v2/pkg/errors"
+)
+
+// JoinGroup makes the UDP listener whose ID is listenerID, see ListenerIDConn.ListenerID, join the multicast group
+// on the interface ifi, the system picks the interface if ifi is nil. Only the datagrams sent by sources are received
+// from the group if any are given (source-specific multicast).
+//
+// The listener must be bound to a multicast address, whose group is joined by the engine on startup, note that
+// some systems like Linux only deliver the datagrams destined for the bound address to the listener, so JoinGroup
+// is mostly useful for receiving the datagrams of the same group from more interfaces or sources.
+func (s Engine) JoinGroup(listenerID int, ifi *net.Interface, group net.IP, sources ...net.IP) error {
+	return s.eng.setGroup(listenerID, true, ifi, group, sources)
+}
+
+// LeaveGroup makes the UDP listener whose ID is listenerID leave the multicast group joined by the engine on
+// startup or by JoinGroup with the same arguments.
+func (s Engine) LeaveGroup(listenerID int, ifi *net.Interface, group net.IP, sources ...net.IP) error {
+	return s.eng.setGroup(listenerID, false, ifi, group, sources)
+}
+
+func (eng *engine) setGroup(listenerID int, join bool, ifi *net.Interface, group net.IP, sources []net.IP) error {
+	// The listeners are closed once the engine starts draining.
+	if eng.isInShutdown() || eng.isDraining() {
+		return errors.ErrEngineInShutdown
+	}
+	if listenerID < 0 || listenerID >= len(eng.listeners) || !eng.listeners[listenerID].multicast {
+		return errors.ErrNotMulticastListener
+	}
+	// The socket is shared among event-loops, see engine.listenerFor.
+	return eng.listeners[listenerID].control(func(fd int) error {
+		if len(sources) == 0 {
+			return setGroup(fd, join, ifi, group, nil)
+		}
+		for _, source := range sources {
+			if err := setGroup(fd, join, ifi, group, source); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func setGroup(fd int, join bool, ifi *net.Interface, group, source net.IP) error {
+	if join {
+		return socket.JoinGroup(fd, ifi, group, source)
+	}
+	return socket.LeaveGroup(fd, ifi, group, source)
+}
+
+// joinMulticast sets up the multicast options on the listener and joins the multicast group it's bound to.
+func (ln *listener) joinMulticast(options *Options) (err error) {
+	addr := ln.addr.(*net.UDPAddr)
+	ipv6 := addr.IP.To4() == nil
+
+	var ifi *net.Interface
+	if ifname := options.MulticastInterface; ifname != "" || addr.Zone != "" {
+		if ifname == "" {
+			ifname = addr.Zone
+		}
+		if ifi, err = net.InterfaceByName(ifname); err != nil {
+			return
+		}
+		if err = socket.SetMulticastInterface(ln.fd, ipv6, ifi); err != nil {
+			return
+		}
+	}
+	// Only receive the datagrams of the groups joined by the listener itself, this is the best effort since
+	// IPV6_MULTICAST_ALL is unavailable on the old Linux kernels.
+	if err = socket.SetMulticastAll(ln.fd, ipv6, 0); err != nil && !ipv6 {
+		return
+	}
+	if options.MulticastTTL > 0 {
+		if err = socket.SetMulticastTTL(ln.fd, ipv6, options.MulticastTTL); err != nil {
+			return
+		}
+	}
+	if options.MulticastLoopback == MulticastNoLoopback {
+		if err = socket.SetMulticastLoopback(ln.fd, ipv6, 0); err != nil {
+			return
+		}
+	}
+
+	if len(options.MulticastSources) == 0 {
+		return socket.JoinGroup(ln.fd, ifi, addr.IP, nil)
+	}
+	for _, s := range options.MulticastSources {
+		source := net.ParseIP(s)
+		if source == nil {
+			return &net.ParseError{Type: "IP address", Text: s}
+		}
+		if err = socket.JoinGroup(ln.fd, ifi, addr.IP, source); err != nil {
+			return
+		}
+	}
+	return nil
+}
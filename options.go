@@ -15,6 +15,7 @@
 package gnet
 
 import (
+	"os"
 	"time"
 
 	"github.com/panjf2000/gnet/v2/pkg/logging"
@@ -40,7 +41,18 @@ const (
 	TCPDelay
 )
 
+// MulticastLoopbackOpt is the type of the option that controls the loopback of multicast datagrams.
+type MulticastLoopbackOpt int
+
+// Available multicast loopback options.
+const (
+	MulticastLoopback MulticastLoopbackOpt = iota
+	MulticastNoLoopback
+)
+
 // Options are configurations for the gnet application.
+//
+// The socket options which are only available on Linux are skipped with a warning logged on the other platforms.
 type Options struct {
 	// ================================== Options for only server-side ==================================
 
@@ -63,6 +75,119 @@ type Options struct {
 	// ReusePort indicates whether to set up the SO_REUSEPORT socket option.
 	ReusePort bool
 
+	// ListenBacklog is the maximum length of the queue of the pending connections of the TCP, unix and unixpacket
+	// listeners, it's the system maximum (somaxconn) if it's not greater than zero, and it's capped by
+	// the system maximum as well.
+	ListenBacklog int
+
+	// AcceptBatchSize is the maximum number of connections accepted in a row when a listener becomes readable,
+	// which handles the bursts of new connections with fewer wake-ups of the acceptor, the acceptor stops
+	// earlier once there are no more pending connections. It's 1 if it's not greater than zero.
+	AcceptBatchSize int
+
+	// MaxConns is the maximum number of concurrent connections accepted by the TCP, unix and unixpacket listeners,
+	// the excess connections are closed right after they're accepted, see RejectPayload. It's unlimited if it's
+	// not greater than zero, note that the connections enrolled by Engine.Enroll are not counted in.
+	MaxConns int
+
+	// MaxConnsPerIP is the maximum number of concurrent TCP connections accepted from the same source IP,
+	// it's unlimited if it's not greater than zero.
+	MaxConnsPerIP int
+
+	// MaxConnsPerCIDR is the maximum number of concurrent TCP connections accepted from the same source network,
+	// whose prefix lengths are CIDRPrefixIPv4 and CIDRPrefixIPv6, it's unlimited if it's not greater than zero.
+	MaxConnsPerCIDR int
+
+	// CIDRPrefixIPv4 is the prefix length of the IPv4 networks limited by MaxConnsPerCIDR,
+	// it's DefaultCIDRPrefixIPv4 if it's not in (0, 32].
+	CIDRPrefixIPv4 int
+
+	// CIDRPrefixIPv6 is the prefix length of the IPv6 networks limited by MaxConnsPerCIDR,
+	// it's DefaultCIDRPrefixIPv6 if it's not in (0, 128].
+	CIDRPrefixIPv6 int
+
+	// RejectPayload is written to the connections rejected by the connection limits right before they're closed,
+	// such as a "server busy" message of the application protocol, it's written without blocking and thus may be
+	// truncated. Nothing is written if it's empty, see Engine.CountRejectedConnections.
+	RejectPayload []byte
+
+	// AcceptRate is the maximum number of connections accepted per second by the TCP, unix and unixpacket listeners,
+	// which is enforced by a token bucket shared by all the listeners of the engine, the listeners stop being
+	// watched until the tokens are refilled once the bucket is empty, and the pending connections stay in
+	// their backlogs in the meantime. It's unlimited if it's not greater than zero.
+	AcceptRate int
+
+	// AcceptBurst is the capacity of the token bucket limiting AcceptRate, which is the maximum number of
+	// connections accepted in a burst, it's AcceptRate if it's not greater than zero.
+	AcceptBurst int
+
+	// TCPFastOpen enables TCP Fast Open (TCP_FASTOPEN) on the TCP listeners with the maximum length of the queue
+	// of the pending TFO requests, the data sent by the clients in the SYN is readable right after the connections
+	// are accepted. It's only available on Linux, note that the server-side TFO also needs to be enabled
+	// by the sysctl net.ipv4.tcp_fastopen.
+	TCPFastOpen int
+
+	// TCPDeferAccept sets up the TCP listeners to wake up the acceptors only when the data arrives on
+	// the new connections (TCP_DEFER_ACCEPT), the connections are accepted without data after the duration
+	// and a retransmission of SYN-ACK. It's only available on Linux.
+	TCPDeferAccept time.Duration
+
+	// GracefulShutdown indicates whether the engine drains the active connections when it's stopped by Stop,
+	// the engine stops accepting new connections, fires OnShutdownNotice for each connection if the EventHandler
+	// implements ShutdownNotifier, and then waits for the connections to be closed until the context
+	// passed to Stop is done.
+	GracefulShutdown bool
+
+	// UnixSocketMode is the file mode of the socket files created by the unix, unixgram and unixpacket listeners,
+	// which is applied before the listeners start listening, the default mode subject to the umask is used
+	// if it's zero. It doesn't apply to the abstract unix sockets on Linux, like `unix://@name`.
+	UnixSocketMode os.FileMode
+
+	// UnixSocketOwner is the user name or the numeric user ID of the owner of the socket files created by
+	// the unix, unixgram and unixpacket listeners, the owner is left unchanged if it's empty.
+	UnixSocketOwner string
+
+	// UnixSocketGroup is the group name or the numeric group ID of the socket files created by the unix,
+	// unixgram and unixpacket listeners, the group is left unchanged if it's empty.
+	UnixSocketGroup string
+
+	// PeerCredentialsFilter authorizes the connections accepted by the unix and unixpacket listeners with the
+	// credentials of the peers before OnOpen fires, the connections are closed right away if it returns false,
+	// or if the credentials can't be retrieved. It's only available on Linux, see PeerCredentialsConn.PeerCredentials.
+	PeerCredentialsFilter func(cred *Credentials) bool
+
+	// UDPGRO indicates whether to set up the UDP_GRO socket option on UDP listeners, with which the kernel coalesces
+	// the datagrams from the same peer into one buffer, the buffer is split back into datagrams before OnTraffic.
+	// It's only available on Linux when batched UDP I/O is enabled, see UDPBatchSize, and ReadBufferCap should be
+	// at least 64KB to hold the coalesced datagrams.
+	UDPGRO bool
+
+	// UDPSessionTimeout enables the stateful UDP sessions on UDP listeners when it's greater than zero,
+	// the datagrams from the same peer share one Conn that keeps its context across datagrams, OnOpen fires
+	// on the first datagram from a peer and OnClose fires with ErrUDPSessionExpired after the session has been
+	// idle for UDPSessionTimeout, or a little longer since the idle sessions are checked periodically.
+	UDPSessionTimeout time.Duration
+
+	// MulticastInterface is the name of the network interface on which the UDP listeners bound to multicast
+	// addresses join the multicast groups and send the multicast datagrams, the system picks one if it's empty,
+	// unless the zone of the IPv6 multicast address is specified.
+	MulticastInterface string
+
+	// MulticastSources makes the UDP listeners bound to multicast addresses join the source-specific multicast
+	// groups, only the datagrams sent by these source IP addresses are received. The datagrams from any source
+	// are received if it's empty.
+	MulticastSources []string
+
+	// MulticastTTL sets up the TTL (IPv4) or the hop limit (IPv6) of the multicast datagrams sent by the UDP
+	// listeners bound to multicast addresses, the system default (1) is used if it's zero.
+	MulticastTTL int
+
+	// MulticastLoopback controls whether the multicast datagrams sent by the UDP listeners bound to multicast
+	// addresses are looped back to the local sockets.
+	//
+	// The default is true (loopback).
+	MulticastLoopback MulticastLoopbackOpt
+
 	// ============================= Options for both server-side and client-side =============================
 
 	// ReadBufferCap is the maximum number of bytes that can be read from the peer when the readable event comes.
@@ -72,6 +197,35 @@ type Options struct {
 	// or equal to its real amount.
 	ReadBufferCap int
 
+	// UDPBatchSize is the maximum number of datagrams read by one recvmmsg(2) call when a UDP socket becomes
+	// readable, OnTraffic is invoked for each datagram and the datagrams written by Conn.Write/Writev during
+	// the batch are coalesced into sendmmsg(2) calls after the batch, in which case the errors of sending
+	// datagrams are logged instead of being returned.
+	//
+	// Batched UDP I/O is only available on Linux and it's disabled when UDPBatchSize is less than 2,
+	// note that every event-loop allocates UDPBatchSize buffers of ReadBufferCap bytes for it.
+	UDPBatchSize int
+
+	// UDPGSO indicates whether to send the consecutive datagrams of the same size queued for the same peer
+	// during a batch in one go with UDP GSO (UDP_SEGMENT), it's only available on Linux when batched UDP I/O
+	// is enabled, see UDPBatchSize, and it's turned off automatically if the kernel rejects it.
+	//
+	// Note that it only applies to the replies written by OnTraffic while a batch of datagrams is being processed,
+	// the datagrams written outside of a batch, e.g. by AsyncWrite or in OnTick, are sent one by one by sendto(2),
+	// and every Write/Writev still makes up exactly one datagram.
+	UDPGSO bool
+
+	// HalfClose indicates whether to keep the TCP and unix connections open when the peers shut down their writing
+	// sides, instead of closing them right away, in which case OnReadEOF fires if the EventHandler implements
+	// ReadEOFNotifier, and the connections are closed once their writing sides are shut down by HalfCloseConn.CloseWrite.
+	HalfClose bool
+
+	// CloseFlushTimeout is the maximum duration for sending the residual data in the outbound buffer of the connection
+	// being closed, during which the connection stops reading from the peer and waits for the writable events
+	// instead of blocking the event-loop, the leftover data is discarded after that. It's DefaultCloseFlushTimeout
+	// if it's not greater than zero.
+	CloseFlushTimeout time.Duration
+
 	// LockOSThread is used to determine whether each I/O event-loop is associated to an OS thread, it is useful when you
 	// need some kind of mechanisms like thread local storage, or invoke certain C libraries (such as graphics lib: GLib)
 	// that require thread-level manipulation via cgo, or want all I/O event-loops to actually run in parallel for a
@@ -84,6 +238,34 @@ type Options struct {
 	// TCPKeepAlive sets up a duration for (SO_KEEPALIVE) socket option.
 	TCPKeepAlive time.Duration
 
+	// TCPKeepAliveInterval sets up the interval between the keep-alive probes (TCP_KEEPINTVL), it's TCPKeepAlive
+	// if it's not greater than zero. It takes effect only when TCPKeepAlive is set.
+	TCPKeepAliveInterval time.Duration
+
+	// TCPKeepAliveCount sets up the number of unacknowledged keep-alive probes before the connection is considered
+	// dead (TCP_KEEPCNT), the system default is used if it's zero. It takes effect only when TCPKeepAlive is set.
+	TCPKeepAliveCount int
+
+	// TCPUserTimeout sets up the maximum duration for which the transmitted data may remain unacknowledged
+	// before the connection is closed forcibly (TCP_USER_TIMEOUT), it's only available on Linux.
+	TCPUserTimeout time.Duration
+
+	// TCPQuickAck enables the quick ACK mode (TCP_QUICKACK) on the TCP sockets, it's only available on Linux.
+	TCPQuickAck bool
+
+	// TCPNotSentLowat sets up the threshold of the unsent bytes in the socket send buffer above which the TCP
+	// sockets are no longer reported as writable (TCP_NOTSENT_LOWAT), it's only available on Linux.
+	TCPNotSentLowat int
+
+	// TCPCongestion sets up the congestion control algorithm of the TCP sockets (TCP_CONGESTION), such as "bbr"
+	// or "cubic", it's only available on Linux.
+	TCPCongestion string
+
+	// TCPFastOpenConnect enables TCP Fast Open (TCP_FASTOPEN_CONNECT) on the TCP connections dialed by Client,
+	// the connections are established right away and the data of the first write is sent in the SYN with
+	// the TFO cookie cached from the server. It's only available on Linux.
+	TCPFastOpenConnect bool
+
 	// TCPNoDelay controls whether the operating system should delay
 	// packet transmission in hopes of sending fewer packets (Nagle's algorithm).
 	//
@@ -97,6 +279,27 @@ type Options struct {
 	// SocketSendBuffer sets the maximum socket send buffer in bytes.
 	SocketSendBuffer int
 
+	// SocketLinger sets up the SO_LINGER socket option of the TCP sockets, the unsent data is sent in the background
+	// for at most SocketLinger (rounded down to seconds, one second at least) when the connections are closed
+	// if it's greater than zero, or discarded with the connections reset if it's less than zero, the system
+	// default is used if it's zero, see also TCPOptionsConn.SetLinger.
+	SocketLinger time.Duration
+
+	// SocketTOS sets up the type-of-service field (IP_TOS) or the traffic class (IPV6_TCLASS) of the packets sent
+	// on the TCP sockets, such as a DSCP value shifted left by two bits.
+	SocketTOS int
+
+	// SocketMark sets up the mark (SO_MARK) of the packets sent on the TCP sockets for the policy routing and
+	// filtering, it's only available on Linux and requires the CAP_NET_ADMIN capability.
+	SocketMark int
+
+	// SocketOptions are the callbacks manipulating the sockets directly, which is meant for the socket options
+	// that are not provided by gnet, they're called in order on the socket of every listener before it's bound,
+	// and on the socket of every connection accepted by the engine, enrolled by Engine.Enroll or dialed by Client
+	// after the other socket options are set, before OnOpen fires. The listener fails to start or the connection
+	// is closed right away if any of them returns an error, see ControlConn.Control for the runtime manipulation.
+	SocketOptions []func(fd int) error
+
 	// LogPath the local path where logs will be written, this is the easiest way to set up logging,
 	// gnet instantiates a default uber-go/zap logger with this given log path, you are also allowed to employ
 	// you own logger during the lifetime by implementing the following log.Logger interface.
@@ -140,6 +343,62 @@ func WithReadBufferCap(readBufferCap int) Option {
 	}
 }
 
+// WithUDPBatchSize sets up UDPBatchSize for reading and sending datagrams in batches.
+func WithUDPBatchSize(udpBatchSize int) Option {
+	return func(opts *Options) {
+		opts.UDPBatchSize = udpBatchSize
+	}
+}
+
+// WithUDPGSO enables/disables UDP generic segmentation offload for sending the replies queued during batched reads.
+func WithUDPGSO(gso bool) Option {
+	return func(opts *Options) {
+		opts.UDPGSO = gso
+	}
+}
+
+// WithUDPGRO enables/disables UDP generic receive offload on UDP listeners.
+func WithUDPGRO(gro bool) Option {
+	return func(opts *Options) {
+		opts.UDPGRO = gro
+	}
+}
+
+// WithUDPSessionTimeout sets up the idle timeout of UDP sessions and enables them on UDP listeners.
+func WithUDPSessionTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.UDPSessionTimeout = timeout
+	}
+}
+
+// WithMulticastInterface sets up the network interface for the UDP listeners bound to multicast addresses.
+func WithMulticastInterface(ifname string) Option {
+	return func(opts *Options) {
+		opts.MulticastInterface = ifname
+	}
+}
+
+// WithMulticastSources sets up the source addresses of the source-specific multicast groups.
+func WithMulticastSources(sources ...string) Option {
+	return func(opts *Options) {
+		opts.MulticastSources = sources
+	}
+}
+
+// WithMulticastTTL sets up the TTL or hop limit of the outgoing multicast datagrams.
+func WithMulticastTTL(ttl int) Option {
+	return func(opts *Options) {
+		opts.MulticastTTL = ttl
+	}
+}
+
+// WithMulticastLoopback enables/disables the loopback of the outgoing multicast datagrams.
+func WithMulticastLoopback(loop MulticastLoopbackOpt) Option {
+	return func(opts *Options) {
+		opts.MulticastLoopback = loop
+	}
+}
+
 // WithLoadBalancing sets up the load-balancing algorithm in gnet engine.
 func WithLoadBalancing(lb LoadBalancing) Option {
 	return func(opts *Options) {
@@ -161,6 +420,73 @@ func WithReusePort(reusePort bool) Option {
 	}
 }
 
+// WithListenBacklog sets up the backlog of the stream listeners.
+func WithListenBacklog(backlog int) Option {
+	return func(opts *Options) {
+		opts.ListenBacklog = backlog
+	}
+}
+
+// WithAcceptBatchSize sets up the maximum number of connections accepted in a row.
+func WithAcceptBatchSize(batchSize int) Option {
+	return func(opts *Options) {
+		opts.AcceptBatchSize = batchSize
+	}
+}
+
+// WithMaxConns sets up the maximum number of concurrent connections accepted by the engine.
+func WithMaxConns(maxConns int) Option {
+	return func(opts *Options) {
+		opts.MaxConns = maxConns
+	}
+}
+
+// WithMaxConnsPerIP sets up the maximum number of concurrent connections accepted from the same source IP.
+func WithMaxConnsPerIP(maxConns int) Option {
+	return func(opts *Options) {
+		opts.MaxConnsPerIP = maxConns
+	}
+}
+
+// WithMaxConnsPerCIDR sets up the maximum number of concurrent connections accepted from the same source network
+// with the prefix lengths of the IPv4 and IPv6 networks.
+func WithMaxConnsPerCIDR(maxConns, prefixIPv4, prefixIPv6 int) Option {
+	return func(opts *Options) {
+		opts.MaxConnsPerCIDR = maxConns
+		opts.CIDRPrefixIPv4 = prefixIPv4
+		opts.CIDRPrefixIPv6 = prefixIPv6
+	}
+}
+
+// WithRejectPayload sets up the data written to the connections rejected by the connection limits.
+func WithRejectPayload(payload []byte) Option {
+	return func(opts *Options) {
+		opts.RejectPayload = payload
+	}
+}
+
+// WithAcceptRateLimit sets up the maximum number of connections accepted per second and in a burst.
+func WithAcceptRateLimit(rate, burst int) Option {
+	return func(opts *Options) {
+		opts.AcceptRate = rate
+		opts.AcceptBurst = burst
+	}
+}
+
+// WithTCPFastOpen enables TCP Fast Open on TCP listeners with the maximum length of the queue of pending requests.
+func WithTCPFastOpen(qlen int) Option {
+	return func(opts *Options) {
+		opts.TCPFastOpen = qlen
+	}
+}
+
+// WithTCPDeferAccept sets up the TCP_DEFER_ACCEPT socket option of TCP listeners with duration.
+func WithTCPDeferAccept(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.TCPDeferAccept = timeout
+	}
+}
+
 // WithReuseAddr sets up SO_REUSEADDR socket option.
 func WithReuseAddr(reuseAddr bool) Option {
 	return func(opts *Options) {
@@ -168,6 +494,49 @@ func WithReuseAddr(reuseAddr bool) Option {
 	}
 }
 
+// WithGracefulShutdown enables/disables draining the active connections when stopping the engine.
+func WithGracefulShutdown(graceful bool) Option {
+	return func(opts *Options) {
+		opts.GracefulShutdown = graceful
+	}
+}
+
+// WithUnixSocketMode sets up the file mode of the socket files created by unix listeners.
+func WithUnixSocketMode(mode os.FileMode) Option {
+	return func(opts *Options) {
+		opts.UnixSocketMode = mode
+	}
+}
+
+// WithUnixSocketOwner sets up the owner and the group of the socket files created by unix listeners.
+func WithUnixSocketOwner(owner, group string) Option {
+	return func(opts *Options) {
+		opts.UnixSocketOwner = owner
+		opts.UnixSocketGroup = group
+	}
+}
+
+// WithPeerCredentialsFilter sets up the filter authorizing the peers of unix connections by their credentials.
+func WithPeerCredentialsFilter(filter func(cred *Credentials) bool) Option {
+	return func(opts *Options) {
+		opts.PeerCredentialsFilter = filter
+	}
+}
+
+// WithHalfClose enables/disables keeping the connections open after the peers shut down their writing sides.
+func WithHalfClose(halfClose bool) Option {
+	return func(opts *Options) {
+		opts.HalfClose = halfClose
+	}
+}
+
+// WithCloseFlushTimeout sets up the maximum duration for flushing the connections being closed.
+func WithCloseFlushTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.CloseFlushTimeout = timeout
+	}
+}
+
 // WithTCPKeepAlive sets up the SO_KEEPALIVE socket option with duration.
 func WithTCPKeepAlive(tcpKeepAlive time.Duration) Option {
 	return func(opts *Options) {
@@ -175,6 +544,55 @@ func WithTCPKeepAlive(tcpKeepAlive time.Duration) Option {
 	}
 }
 
+// WithTCPKeepAliveInterval sets up the TCP_KEEPINTVL socket option with duration.
+func WithTCPKeepAliveInterval(interval time.Duration) Option {
+	return func(opts *Options) {
+		opts.TCPKeepAliveInterval = interval
+	}
+}
+
+// WithTCPKeepAliveCount sets up the TCP_KEEPCNT socket option.
+func WithTCPKeepAliveCount(count int) Option {
+	return func(opts *Options) {
+		opts.TCPKeepAliveCount = count
+	}
+}
+
+// WithTCPUserTimeout sets up the TCP_USER_TIMEOUT socket option with duration.
+func WithTCPUserTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.TCPUserTimeout = timeout
+	}
+}
+
+// WithTCPQuickAck enable/disable the TCP_QUICKACK socket option.
+func WithTCPQuickAck(quickAck bool) Option {
+	return func(opts *Options) {
+		opts.TCPQuickAck = quickAck
+	}
+}
+
+// WithTCPNotSentLowat sets up the TCP_NOTSENT_LOWAT socket option in bytes.
+func WithTCPNotSentLowat(lowat int) Option {
+	return func(opts *Options) {
+		opts.TCPNotSentLowat = lowat
+	}
+}
+
+// WithTCPCongestion sets up the congestion control algorithm of TCP sockets.
+func WithTCPCongestion(name string) Option {
+	return func(opts *Options) {
+		opts.TCPCongestion = name
+	}
+}
+
+// WithTCPFastOpenConnect enables/disables TCP Fast Open on the TCP connections dialed by Client.
+func WithTCPFastOpenConnect(fastOpen bool) Option {
+	return func(opts *Options) {
+		opts.TCPFastOpenConnect = fastOpen
+	}
+}
+
 // WithTCPNoDelay enable/disable the TCP_NODELAY socket option.
 func WithTCPNoDelay(tcpNoDelay TCPSocketOpt) Option {
 	return func(opts *Options) {
@@ -196,6 +614,34 @@ func WithSocketSendBuffer(sendBuf int) Option {
 	}
 }
 
+// WithSocketLinger sets up the SO_LINGER socket option with duration.
+func WithSocketLinger(linger time.Duration) Option {
+	return func(opts *Options) {
+		opts.SocketLinger = linger
+	}
+}
+
+// WithSocketTOS sets up the IP_TOS/IPV6_TCLASS socket option.
+func WithSocketTOS(tos int) Option {
+	return func(opts *Options) {
+		opts.SocketTOS = tos
+	}
+}
+
+// WithSocketMark sets up the SO_MARK socket option.
+func WithSocketMark(mark int) Option {
+	return func(opts *Options) {
+		opts.SocketMark = mark
+	}
+}
+
+// WithSocketOptions appends the callbacks manipulating the sockets of listeners and connections.
+func WithSocketOptions(sockOpts ...func(fd int) error) Option {
+	return func(opts *Options) {
+		opts.SocketOptions = append(opts.SocketOptions, sockOpts...)
+	}
+}
+
 // WithTicker indicates that a ticker is set.
 func WithTicker(ticker bool) Option {
 	return func(opts *Options) {
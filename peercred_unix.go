@@ -0,0 +1,64 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || darwin
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"strings"
+
+	"github.com/panjf2000/gnet/v2/internal/socket"
+	"github.com/panjf2000/gnet/v2/pkg/errors"
+)
+
+func (c *conn) PeerCredentials() (*Credentials, error) {
+	if c.peerCred != nil {
+		return c.peerCred, nil
+	}
+	if !c.isUnixStream() {
+		return nil, errors.ErrUnsupportedOp
+	}
+	cred, err := getPeerCredentials(c.fd)
+	if err != nil {
+		return nil, err
+	}
+	c.peerCred = cred
+	return cred, nil
+}
+
+func getPeerCredentials(fd int) (*Credentials, error) {
+	pid, uid, gid, err := socket.GetPeerCred(fd)
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{PID: pid, UID: uid, GID: gid}, nil
+}
+
+// authorizePeer authorizes the peer of the connection nfd accepted by ln with Options.PeerCredentialsFilter
+// and returns its credentials, it's a no-op if the filter is not set or ln is not a unix or unixpacket listener.
+func (ln *listener) authorizePeer(opts *Options, nfd int) (*Credentials, error) {
+	if opts.PeerCredentialsFilter == nil || !strings.HasPrefix(ln.network, "unix") || ln.isDatagram() {
+		return nil, nil
+	}
+	cred, err := getPeerCredentials(nfd)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.PeerCredentialsFilter(cred) {
+		return nil, errors.ErrPeerUnauthorized
+	}
+	return cred, nil
+}
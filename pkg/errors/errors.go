@@ -26,17 +26,37 @@ var (
 	// ErrTooManyEventLoopThreads occurs when attempting to set up more than 10,000 event-loop goroutines under LockOSThread mode.
 	ErrTooManyEventLoopThreads = errors.New("too many event-loops under LockOSThread mode")
 	// ErrUnsupportedProtocol occurs when trying to use protocol that is not supported.
-	ErrUnsupportedProtocol = errors.New("only unix, tcp/tcp4/tcp6, udp/udp4/udp6 are supported")
+	ErrUnsupportedProtocol = errors.New("only unix/unixgram/unixpacket, tcp/tcp4/tcp6, udp/udp4/udp6 are supported")
 	// ErrUnsupportedTCPProtocol occurs when trying to use an unsupported TCP protocol.
 	ErrUnsupportedTCPProtocol = errors.New("only tcp/tcp4/tcp6 are supported")
 	// ErrUnsupportedUDPProtocol occurs when trying to use an unsupported UDP protocol.
 	ErrUnsupportedUDPProtocol = errors.New("only udp/udp4/udp6 are supported")
 	// ErrUnsupportedUDSProtocol occurs when trying to use an unsupported Unix protocol.
-	ErrUnsupportedUDSProtocol = errors.New("only unix is supported")
+	ErrUnsupportedUDSProtocol = errors.New("only unix/unixgram/unixpacket are supported")
+	// ErrActivatedListenerNotFound occurs when the socket referred to by fd:// is not passed by the service manager.
+	ErrActivatedListenerNotFound = errors.New("no such listener passed via socket activation")
+	// ErrListenerClosed occurs when trying to use a listener that has been closed, e.g. after the engine is drained.
+	ErrListenerClosed = errors.New("listener is closed")
+	// ErrRestartNotReady occurs when the new process started by Engine.Restart exits before it gets ready.
+	ErrRestartNotReady = errors.New("new process exited before getting ready")
+	// ErrEmptyProtoAddrs occurs when trying to run an engine without any protocol address.
+	ErrEmptyProtoAddrs = errors.New("no protocol address to listen on")
+	// ErrUDPSessionExpired occurs when a UDP session is closed after being idle for too long.
+	ErrUDPSessionExpired = errors.New("UDP session expired")
+	// ErrNotMulticastListener occurs when the listener doesn't exist or isn't bound to a multicast address.
+	ErrNotMulticastListener = errors.New("no such UDP listener bound to a multicast address")
+	// ErrNoDataForFds occurs when trying to send file descriptors without any data.
+	ErrNoDataForFds = errors.New("file descriptors must be sent along with at least one byte of data")
+	// ErrPeerUnauthorized occurs when the credentials of the peer are rejected by the filter.
+	ErrPeerUnauthorized = errors.New("peer credentials are rejected by the filter")
+	// ErrWriteClosed occurs when writing data to a connection whose writing side has been shut down.
+	ErrWriteClosed = errors.New("writing side of the connection is closed")
 	// ErrUnsupportedPlatform occurs when running gnet on an unsupported platform.
 	ErrUnsupportedPlatform = errors.New("unsupported platform in gnet")
 	// ErrConnectionClosed occurs when the event-loop receives a closed connection.
 	ErrConnectionClosed = errors.New("connection is closed")
+	// ErrConnectionAborted occurs when the connection is closed by AbortableConn.Abort.
+	ErrConnectionAborted = errors.New("connection is aborted")
 	// ErrBufferFull occurs when trying to read bytes that is larger than the buffer size.
 	ErrBufferFull = errors.New("buffer full")
 	// ErrUnsupportedOp occurs when calling some methods that has not been implemented yet.
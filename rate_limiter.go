@@ -0,0 +1,68 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket limiting the rate of accepting connections, see Options.AcceptRate.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64   // tokens refilled per second
+	burst  float64   // capacity of the bucket
+	tokens float64   // available tokens, which may go negative when they're overdrawn by concurrent acceptors
+	last   time.Time // last time the tokens were refilled
+}
+
+// newRateLimiter returns the rate limiter with rate and burst, nil if rate is not greater than zero.
+func newRateLimiter(rate, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	return &rateLimiter{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// refill adds the tokens accumulated since the last refill, it must be called with mu held.
+func (l *rateLimiter) refill() {
+	now := time.Now()
+	if l.tokens += now.Sub(l.last).Seconds() * l.rate; l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+// wait returns the duration until a token is available, which is zero if there is one already.
+func (l *rateLimiter) wait() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	if l.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// take consumes a token for the connection that is just accepted.
+func (l *rateLimiter) take() {
+	l.mu.Lock()
+	l.refill()
+	l.tokens--
+	l.mu.Unlock()
+}
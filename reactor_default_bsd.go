@@ -21,7 +21,6 @@ package gnet
 import (
 	"runtime"
 
-	"github.com/panjf2000/gnet/v2/internal/netpoll"
 	"github.com/panjf2000/gnet/v2/pkg/errors"
 )
 
@@ -49,21 +48,19 @@ func (el *eventloop) activateSubReactor(lockOSThread bool) {
 
 	defer func() {
 		el.closeAllSockets()
+		el.closeListeners()
 		el.engine.signalShutdown()
 	}()
 
 	err := el.poller.Polling(func(fd int, filter int16) (err error) {
 		if c, ack := el.connections[fd]; ack {
-			switch filter {
-			case netpoll.EVFilterSock:
-				err = el.closeConn(c, nil)
-			case netpoll.EVFilterWrite:
-				if !c.outboundBuffer.IsEmpty() {
-					err = el.write(c)
-				}
-			case netpoll.EVFilterRead:
-				err = el.read(c)
-			}
+			return c.handleEvents(fd, filter)
+		}
+		if _, ok := el.listeners[fd]; ok { // UDP listener
+			return el.accept(fd, filter)
+		}
+		if _, ok := el.udpSockets[fd]; ok {
+			return el.readUDP(fd, filter)
 		}
 		return
 	})
@@ -82,23 +79,13 @@ func (el *eventloop) run(lockOSThread bool) {
 
 	defer func() {
 		el.closeAllSockets()
-		el.ln.close()
+		el.closeListeners()
 		el.engine.signalShutdown()
 	}()
 
 	err := el.poller.Polling(func(fd int, filter int16) (err error) {
 		if c, ack := el.connections[fd]; ack {
-			switch filter {
-			case netpoll.EVFilterSock:
-				err = el.closeConn(c, nil)
-			case netpoll.EVFilterWrite:
-				if !c.outboundBuffer.IsEmpty() {
-					err = el.write(c)
-				}
-			case netpoll.EVFilterRead:
-				err = el.read(c)
-			}
-			return
+			return c.handleEvents(fd, filter)
 		}
 		return el.accept(fd, filter)
 	})
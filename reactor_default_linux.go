@@ -20,7 +20,6 @@ package gnet
 import (
 	"runtime"
 
-	"github.com/panjf2000/gnet/v2/internal/netpoll"
 	"github.com/panjf2000/gnet/v2/pkg/errors"
 )
 
@@ -48,38 +47,19 @@ func (el *eventloop) activateSubReactor(lockOSThread bool) {
 
 	defer func() {
 		el.closeAllSockets()
+		el.closeListeners()
 		el.engine.signalShutdown()
 	}()
 
 	err := el.poller.Polling(func(fd int, ev uint32) error {
 		if c, ack := el.connections[fd]; ack {
-			// Don't change the ordering of processing EPOLLOUT | EPOLLRDHUP / EPOLLIN unless you're 100%
-			// sure what you're doing!
-			// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
-
-			// We should always check for the EPOLLOUT event first, as we must try to send the leftover data back to
-			// the peer when any error occurs on a connection.
-			//
-			// Either an EPOLLOUT or EPOLLERR event may be fired when a connection is refused.
-			// In either case write() should take care of it properly:
-			// 1) writing data back,
-			// 2) closing the connection.
-			if ev&netpoll.OutEvents != 0 && !c.outboundBuffer.IsEmpty() {
-				if err := el.write(c); err != nil {
-					return err
-				}
-			}
-
-			// If there is pending data in outbound buffer, then we should omit this readable event
-			// and prioritize the writable events to get a lower latency and may achieve a higher performance.
-			//
-			// Note that the peer may send massive amounts of data to us by write() under blocking mode,
-			// resulting in that it won't receive any responses before we read all data from the peer,
-			// in which case if the local send buffer is full, we need to let it go and continue reading
-			// the data to prevent blocking forever.
-			if ev&netpoll.InEvents != 0 && (ev&netpoll.OutEvents == 0 || c.outboundBuffer.IsEmpty()) {
-				return el.read(c)
-			}
+			return c.handleEvents(fd, ev)
+		}
+		if _, ok := el.listeners[fd]; ok { // UDP listener
+			return el.accept(fd, ev)
+		}
+		if _, ok := el.udpSockets[fd]; ok {
+			return el.readUDP(fd, ev)
 		}
 		return nil
 	})
@@ -98,40 +78,13 @@ func (el *eventloop) run(lockOSThread bool) {
 
 	defer func() {
 		el.closeAllSockets()
-		el.ln.close()
+		el.closeListeners()
 		el.engine.signalShutdown()
 	}()
 
 	err := el.poller.Polling(func(fd int, ev uint32) error {
 		if c, ok := el.connections[fd]; ok {
-			// Don't change the ordering of processing EPOLLOUT | EPOLLRDHUP / EPOLLIN unless you're 100%
-			// sure what you're doing!
-			// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
-
-			// We should always check for the EPOLLOUT event first, as we must try to send the leftover data back to
-			// the peer when any error occurs on a connection.
-			//
-			// Either an EPOLLOUT or EPOLLERR event may be fired when a connection is refused.
-			// In either case write() should take care of it properly:
-			// 1) writing data back,
-			// 2) closing the connection.
-			if ev&netpoll.OutEvents != 0 && !c.outboundBuffer.IsEmpty() {
-				if err := el.write(c); err != nil {
-					return err
-				}
-			}
-
-			// If there is pending data in outbound buffer, then we should omit this readable event
-			// and prioritize the writable events to get a lower latency and may achieve a higher performance.
-			//
-			// Note that the peer may send massive amounts of data to us by write() under blocking mode,
-			// resulting in that it won't receive any responses before we read all data from the peer,
-			// in which case if the local send buffer is full, we need to let it go and continue reading
-			// the data to prevent blocking forever.
-			if ev&netpoll.InEvents != 0 && (ev&netpoll.OutEvents == 0 || c.outboundBuffer.IsEmpty()) {
-				return el.read(c)
-			}
-			return nil
+			return c.handleEvents(fd, ev)
 		}
 		return el.accept(fd, ev)
 	})
@@ -47,6 +47,7 @@ func (el *eventloop) activateSubReactor(lockOSThread bool) {
 
 	defer func() {
 		el.closeAllSockets()
+		el.closeListeners()
 		el.engine.signalShutdown()
 	}()
 
@@ -66,7 +67,7 @@ func (el *eventloop) run(lockOSThread bool) {
 
 	defer func() {
 		el.closeAllSockets()
-		el.ln.close()
+		el.closeListeners()
 		el.engine.signalShutdown()
 	}()
 
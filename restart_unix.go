@@ -0,0 +1,252 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || darwin
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/v2/pkg/errors"
+	"github.com/panjf2000/gnet/v2/pkg/logging"
+)
+
+// InheritedFdsEnv is the environment variable through which the listeners are passed on to the
+// child process during a graceful restart, it's formatted like `tcp://:9000=3;udp://:9001=4`.
+const InheritedFdsEnv = "GNET_INHERITED_FDS"
+
+// restartReadyFdEnv is the environment variable through which the write end of the pipe is passed on to the
+// child process during a graceful restart, the child reports its readiness by writing to the pipe.
+const restartReadyFdEnv = "GNET_RESTART_READY_FD"
+
+var inherited struct {
+	sync.Mutex
+	fds   map[string][]int // listener key -> inherited file descriptors
+	ready *os.File         // write end of the pipe for reporting the readiness to the parent process
+}
+
+// inheritKey returns the key of the listener passed on to the child process.
+func (ln *listener) inheritKey() string {
+	if ln.activated {
+		return inheritKey("fd", ln.address)
+	}
+	return inheritKey(ln.network, ln.address)
+}
+
+// inheritKey builds the key of the listener with the normalized network.
+func inheritKey(network, address string) string {
+	switch network {
+	case "tcp4", "tcp6":
+		network = "tcp"
+	case "udp4", "udp6":
+		network = "udp"
+	}
+	return network + "://" + address
+}
+
+// loadInheritedFds parses the listeners passed on by the parent process, the environment variable
+// is cleared afterwards to prevent it from being leaked to other processes spawned by this process.
+func loadInheritedFds() {
+	if env, ok := os.LookupEnv(restartReadyFdEnv); ok {
+		_ = os.Unsetenv(restartReadyFdEnv)
+		if fd, err := strconv.Atoi(env); err == nil && fd > 2 {
+			unix.CloseOnExec(fd)
+			inherited.ready = os.NewFile(uintptr(fd), "restart-ready")
+		} else {
+			logging.Warnf("invalid file descriptor of the restart readiness pipe: %s", env)
+		}
+	}
+	env, ok := os.LookupEnv(InheritedFdsEnv)
+	if !ok {
+		return
+	}
+	_ = os.Unsetenv(InheritedFdsEnv)
+	if inherited.fds == nil {
+		inherited.fds = make(map[string][]int)
+	}
+	for _, entry := range strings.Split(env, ";") {
+		i := strings.LastIndexByte(entry, '=')
+		if i < 0 {
+			continue
+		}
+		fd, err := strconv.Atoi(entry[i+1:])
+		if err != nil || fd < 0 {
+			logging.Warnf("invalid inherited listener: %s", entry)
+			continue
+		}
+		inherited.fds[entry[:i]] = append(inherited.fds[entry[:i]], fd)
+	}
+}
+
+// takeInheritedFd returns the inherited file descriptor of the listener with the given network and address.
+func takeInheritedFd(network, address string) (int, bool) {
+	inherited.Lock()
+	defer inherited.Unlock()
+	loadInheritedFds()
+	key := inheritKey(network, address)
+	fds := inherited.fds[key]
+	if len(fds) == 0 {
+		return 0, false
+	}
+	fd := fds[0]
+	if len(fds) == 1 {
+		delete(inherited.fds, key)
+	} else {
+		inherited.fds[key] = fds[1:]
+	}
+	return fd, true
+}
+
+// closeInheritedFds closes the inherited file descriptors of the same addresses as listeners that haven't been
+// claimed by them, otherwise the connections queued on them would never be accepted. The ones of the other
+// addresses are left for the other engines running in the same process.
+func closeInheritedFds(listeners []*listener) {
+	inherited.Lock()
+	defer inherited.Unlock()
+	for _, ln := range listeners {
+		key := ln.inheritKey()
+		for _, fd := range inherited.fds[key] {
+			logging.Warnf("closing unclaimed inherited listener %s, fd=%d", key, fd)
+			_ = unix.Close(fd)
+		}
+		delete(inherited.fds, key)
+	}
+}
+
+// notifyRestartReady reports the readiness to the parent process that started this process by Engine.Restart,
+// it's called once an engine serving the inherited listeners has started.
+func notifyRestartReady() {
+	inherited.Lock()
+	defer inherited.Unlock()
+	if inherited.ready == nil {
+		return
+	}
+	if _, err := inherited.ready.Write([]byte{1}); err != nil {
+		logging.Warnf("failed to report the readiness to the parent process: %v", err)
+	}
+	_ = inherited.ready.Close()
+	inherited.ready = nil
+}
+
+// Restart starts a new process with the same executable, arguments and environment as the current one,
+// the listeners of the engine are passed on to the new process which adopts them in Run instead of
+// creating new sockets, so that no connection will be refused during the restart.
+//
+// Restart waits until an engine serving the inherited listeners has started in the new process, the new process
+// is killed and the engine keeps serving if it fails to start before ctx is done. Otherwise, the engine stops
+// accepting new connections and drains the active connections just like Stop does with Options.GracefulShutdown,
+// Run returns after the engine is shut down.
+func (s Engine) Restart(ctx context.Context) error {
+	eng := s.eng
+	if eng.isInShutdown() || eng.isDraining() {
+		return errors.ErrEngineInShutdown
+	}
+
+	// The event-loops of the child process set up their own copies of the listeners like this process does.
+	lns := eng.listeners
+	files := make([]*os.File, 0, len(lns)+1)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	entries := make([]string, 0, len(lns))
+	for i, ln := range lns {
+		dupFD, sc, err := ln.dup()
+		if err != nil {
+			return os.NewSyscallError(sc, err)
+		}
+		files = append(files, os.NewFile(uintptr(dupFD), ln.network+"://"+ln.address))
+		// The file descriptors in ExtraFiles are numbered from 3 in the child process.
+		entries = append(entries, fmt.Sprintf("%s=%d", ln.inheritKey(), 3+i))
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	files = append(files, readyW)
+
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, InheritedFdsEnv+"=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env, InheritedFdsEnv+"="+strings.Join(entries, ";"),
+		restartReadyFdEnv+"="+strconv.Itoa(3+len(lns)))
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	// Close the write end in this process, so that reading from the pipe fails once the child process exits.
+	_ = readyW.Close()
+	files = files[:len(files)-1]
+	if err = awaitRestartReady(ctx, ready); err != nil {
+		eng.opts.Logger.Errorf("new process(%d) failed to get ready, keep serving: %v", cmd.Process.Pid, err)
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return err
+	}
+	eng.opts.Logger.Infof("new process(%d) is serving with %d inherited listener(s)", cmd.Process.Pid, len(lns))
+	_ = cmd.Process.Release()
+
+	// The listening sockets are being served by the new process now,
+	// make sure the unix socket files won't be removed when closing the listeners.
+	for _, ln := range lns {
+		ln.transfer()
+	}
+
+	eng.drain(ctx)
+	eng.signalShutdown()
+	return eng.awaitShutdown(ctx)
+}
+
+// awaitRestartReady waits until the child process reports its readiness through the pipe ready or ctx is done.
+func awaitRestartReady(ctx context.Context, ready *os.File) error {
+	errCh := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		_, err := ready.Read(b)
+		if err == io.EOF {
+			err = errors.ErrRestartNotReady
+		}
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		// Unblock the reader.
+		_ = ready.SetReadDeadline(time.Now())
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/v2/internal/socket"
+)
+
+func TestTCPSocketOptions(t *testing.T) {
+	congestion, err := os.ReadFile("/proc/sys/net/ipv4/tcp_congestion_control")
+	require.NoError(t, err)
+	opts := []Option{
+		WithTCPKeepAlive(time.Second),
+		WithTCPKeepAliveInterval(2 * time.Second),
+		WithTCPKeepAliveCount(3),
+		WithTCPUserTimeout(5 * time.Second),
+		WithTCPNotSentLowat(16 * 1024),
+		WithTCPCongestion(strings.TrimSpace(string(congestion))),
+		WithSocketLinger(3 * time.Second),
+		WithSocketTOS(0x10),
+	}
+	t.Run("1-loop", func(t *testing.T) {
+		testTCPSocketOptions(t, ":7216", opts...)
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testTCPSocketOptions(t, ":7217", append(opts, WithMulticore(true))...)
+	})
+}
+
+type testTCPSocketOptionsServer struct {
+	*testDrivenServer
+	congestion string
+}
+
+func (t *testTCPSocketOptionsServer) OnOpen(c Conn) (out []byte, action Action) {
+	fd := c.(*conn).fd
+	getInt := func(level, opt int) int {
+		v, err := unix.GetsockoptInt(fd, level, opt)
+		require.NoError(t.tester, err)
+		return v
+	}
+	require.EqualValues(t.tester, 1, getInt(unix.SOL_SOCKET, unix.SO_KEEPALIVE))
+	require.EqualValues(t.tester, 1, getInt(unix.IPPROTO_TCP, unix.TCP_KEEPIDLE))
+	require.EqualValues(t.tester, 2, getInt(unix.IPPROTO_TCP, unix.TCP_KEEPINTVL))
+	require.EqualValues(t.tester, 3, getInt(unix.IPPROTO_TCP, unix.TCP_KEEPCNT))
+	require.EqualValues(t.tester, 5000, getInt(unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT))
+	require.EqualValues(t.tester, 16*1024, getInt(unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT))
+	require.EqualValues(t.tester, 0x10, getInt(unix.IPPROTO_IP, unix.IP_TOS))
+	linger, err := unix.GetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER)
+	require.NoError(t.tester, err)
+	require.EqualValues(t.tester, 1, linger.Onoff)
+	require.EqualValues(t.tester, 3, linger.Linger)
+	congestion, err := unix.GetsockoptString(fd, unix.IPPROTO_TCP, unix.TCP_CONGESTION)
+	require.NoError(t.tester, err)
+	require.EqualValues(t.tester, t.congestion, strings.TrimRight(congestion, "\x00"))
+
+	// Override the socket options of the connection.
+	tc := c.(TCPOptionsConn)
+	require.NoError(t.tester, tc.SetKeepAlive(10*time.Second, 0, 5))
+	require.EqualValues(t.tester, 10, getInt(unix.IPPROTO_TCP, unix.TCP_KEEPIDLE))
+	require.EqualValues(t.tester, 10, getInt(unix.IPPROTO_TCP, unix.TCP_KEEPINTVL))
+	require.EqualValues(t.tester, 5, getInt(unix.IPPROTO_TCP, unix.TCP_KEEPCNT))
+	require.Error(t.tester, tc.SetKeepAlive(0, 0, 0))
+	require.NoError(t.tester, tc.SetUserTimeout(time.Second))
+	require.EqualValues(t.tester, 1000, getInt(unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT))
+	require.NoError(t.tester, tc.SetNotSentLowat(4096))
+	require.EqualValues(t.tester, 4096, getInt(unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT))
+	require.NoError(t.tester, tc.SetTOS(0x20))
+	require.EqualValues(t.tester, 0x20, getInt(unix.IPPROTO_IP, unix.IP_TOS))
+	require.NoError(t.tester, tc.SetQuickAck(true))
+	require.NoError(t.tester, tc.SetCongestion(t.congestion))
+	require.NoError(t.tester, tc.SetLinger(-time.Second))
+	linger, err = unix.GetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER)
+	require.NoError(t.tester, err)
+	require.EqualValues(t.tester, 1, linger.Onoff)
+	require.EqualValues(t.tester, 0, linger.Linger)
+	require.NoError(t.tester, tc.SetLinger(0))
+	linger, err = unix.GetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER)
+	require.NoError(t.tester, err)
+	require.EqualValues(t.tester, 0, linger.Onoff)
+	return
+}
+
+func testTCPSocketOptions(t *testing.T, addr string, opts ...Option) {
+	options := loadOptions(opts...)
+	ts := &testTCPSocketOptionsServer{congestion: options.TCPCongestion}
+	ts.testDrivenServer = newTestDrivenServer(t, echoClient(t, "tcp", addr))
+	ts.run(ts, "tcp://"+addr, opts...)
+}
+
+func TestTCPFastOpen(t *testing.T) {
+	opts := []Option{WithTCPFastOpen(16), WithTCPDeferAccept(time.Second)}
+	t.Run("1-loop", func(t *testing.T) {
+		testTCPFastOpen(t, ":7220", opts...)
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testTCPFastOpen(t, ":7221", append(opts, WithMulticore(true))...)
+	})
+}
+
+type testTCPFastOpenServer struct {
+	*testDrivenServer
+	addr string
+}
+
+func (t *testTCPFastOpenServer) OnBoot(eng Engine) (action Action) {
+	fd, err := eng.DupFd()
+	require.NoError(t.tester, err)
+	defer unix.Close(fd) //nolint:errcheck
+	qlen, err := unix.GetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN)
+	require.NoError(t.tester, err)
+	require.EqualValues(t.tester, 16, qlen)
+	secs, err := unix.GetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT)
+	require.NoError(t.tester, err)
+	require.EqualValues(t.tester, 1, secs)
+	return
+}
+
+func (t *testTCPFastOpenServer) runClient() {
+	// The connection dialed with TCP Fast Open isn't established until the first write.
+	handler := &testTCPFastOpenClient{data: make(chan []byte, 1)}
+	cli, err := NewClient(handler, WithTCPFastOpenConnect(true))
+	require.NoError(t.tester, err)
+	require.NoError(t.tester, cli.Start())
+	defer cli.Stop() //nolint:errcheck
+	c, err := cli.Dial("tcp", "127.0.0.1"+t.addr)
+	require.NoError(t.tester, err)
+	v, err := unix.GetsockoptInt(c.(*conn).fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT)
+	require.NoError(t.tester, err)
+	require.EqualValues(t.tester, 1, v)
+	require.NoError(t.tester, c.AsyncWrite([]byte("hello")))
+	select {
+	case data := <-handler.data:
+		require.EqualValues(t.tester, "hello", data)
+	case <-time.After(5 * time.Second):
+		require.Fail(t.tester, "no response from the server")
+	}
+}
+
+type testTCPFastOpenClient struct {
+	*BuiltinEventEngine
+	data chan []byte
+}
+
+func (t *testTCPFastOpenClient) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	t.data <- append([]byte(nil), buf...)
+	return
+}
+
+func testTCPFastOpen(t *testing.T, addr string, opts ...Option) {
+	ts := &testTCPFastOpenServer{addr: addr}
+	ts.testDrivenServer = newTestDrivenServer(t, ts.runClient)
+	ts.run(ts, "tcp://"+addr, opts...)
+}
+
+func TestTCPInfo(t *testing.T) {
+	// The layout of struct tcp_info up to tcpi_snd_wnd.
+	require.EqualValues(t, 232, unsafe.Sizeof(socket.TCPInfo{}))
+	t.Run("1-loop", func(t *testing.T) {
+		testTCPInfo(t, ":7222")
+	})
+	t.Run("N-loop", func(t *testing.T) {
+		testTCPInfo(t, ":7223", WithMulticore(true))
+	})
+}
+
+type testTCPInfoServer struct {
+	*testDrivenServer
+	sampled int32
+}
+
+func (t *testTCPInfoServer) OnCloseTCPInfo(_ Conn, info *TCPInfo) {
+	require.GreaterOrEqual(t.tester, info.BytesReceived, uint64(5))
+	atomic.AddInt32(&t.sampled, 1)
+}
+
+func (t *testTCPInfoServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	info, err := c.(TCPInfoConn).TCPInfo()
+	require.NoError(t.tester, err)
+	require.EqualValues(t.tester, unix.BPF_TCP_ESTABLISHED, info.State)
+	require.GreaterOrEqual(t.tester, info.BytesReceived, uint64(len(buf)))
+	require.Greater(t.tester, info.RTT, time.Duration(0))
+	require.Greater(t.tester, info.RTO, time.Duration(0))
+	require.Greater(t.tester, info.SndCwnd, uint32(0))
+	require.Greater(t.tester, info.SndMSS, uint32(0))
+	_, _ = c.Write(buf)
+	return
+}
+
+func testTCPInfo(t *testing.T, addr string, opts ...Option) {
+	ts := &testTCPInfoServer{}
+	ts.testDrivenServer = newTestDrivenServer(t, echoClient(t, "tcp", addr))
+	ts.run(ts, "tcp://"+addr, opts...)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&ts.sampled))
+}
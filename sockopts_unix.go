@@ -0,0 +1,252 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || darwin
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/panjf2000/gnet/v2/internal/socket"
+	"github.com/panjf2000/gnet/v2/pkg/errors"
+	"github.com/panjf2000/gnet/v2/pkg/logging"
+)
+
+// tcpSockOpts returns the TCP socket options set up by options, which are applied to the TCP listeners
+// as well as the TCP connections accepted by the engine, enrolled by Engine.Enroll or dialed by Client.
+func tcpSockOpts(options *Options) (sockOpts []socket.Option) {
+	if options.TCPKeepAlive > 0 {
+		sockOpt := socket.Option{SetSockOpt: socket.SetKeepAlive, Opt: seconds(options.TCPKeepAlive)}
+		sockOpts = append(sockOpts, sockOpt)
+		if options.TCPKeepAliveInterval > 0 {
+			sockOpt = socket.Option{SetSockOpt: socket.SetKeepAliveInterval, Opt: seconds(options.TCPKeepAliveInterval)}
+			sockOpts = append(sockOpts, sockOpt)
+		}
+		if options.TCPKeepAliveCount > 0 {
+			sockOpt = socket.Option{SetSockOpt: socket.SetKeepAliveCount, Opt: options.TCPKeepAliveCount}
+			sockOpts = append(sockOpts, sockOpt)
+		}
+	}
+	if options.TCPUserTimeout > 0 {
+		setUserTimeout := optionalSockOpt(options.Logger, "TCP_USER_TIMEOUT", socket.SetUserTimeout)
+		sockOpt := socket.Option{SetSockOpt: setUserTimeout, Opt: int(options.TCPUserTimeout / time.Millisecond)}
+		sockOpts = append(sockOpts, sockOpt)
+	}
+	if options.TCPQuickAck {
+		setQuickAck := optionalSockOpt(options.Logger, "TCP_QUICKACK", socket.SetQuickAck)
+		sockOpt := socket.Option{SetSockOpt: setQuickAck, Opt: 1}
+		sockOpts = append(sockOpts, sockOpt)
+	}
+	if options.TCPNotSentLowat > 0 {
+		setNotSentLowat := optionalSockOpt(options.Logger, "TCP_NOTSENT_LOWAT", socket.SetNotSentLowat)
+		sockOpt := socket.Option{SetSockOpt: setNotSentLowat, Opt: options.TCPNotSentLowat}
+		sockOpts = append(sockOpts, sockOpt)
+	}
+	if name := options.TCPCongestion; name != "" {
+		setCongestion := optionalSockOpt(options.Logger, "TCP_CONGESTION",
+			func(fd, _ int) error { return socket.SetCongestion(fd, name) })
+		sockOpt := socket.Option{SetSockOpt: setCongestion}
+		sockOpts = append(sockOpts, sockOpt)
+	}
+	if options.SocketLinger != 0 {
+		sockOpt := socket.Option{SetSockOpt: socket.SetLinger, Opt: lingerSeconds(options.SocketLinger)}
+		sockOpts = append(sockOpts, sockOpt)
+	}
+	if options.SocketTOS > 0 {
+		sockOpt := socket.Option{SetSockOpt: socket.SetTOS, Opt: options.SocketTOS}
+		sockOpts = append(sockOpts, sockOpt)
+	}
+	if options.SocketMark > 0 {
+		setMark := optionalSockOpt(options.Logger, "SO_MARK", socket.SetMark)
+		sockOpt := socket.Option{SetSockOpt: setMark, Opt: options.SocketMark}
+		sockOpts = append(sockOpts, sockOpt)
+	}
+	return
+}
+
+// optionalSockOpt wraps setSockOpt of a socket option which is not available on all platforms, the option
+// is skipped with a warning logged once instead of failing the sockets where it's unsupported.
+func optionalSockOpt(logger logging.Logger, name string, setSockOpt func(fd, opt int) error) func(fd, opt int) error {
+	var once sync.Once
+	return func(fd, opt int) error {
+		err := setSockOpt(fd, opt)
+		if err == errors.ErrUnsupportedOp {
+			once.Do(func() { logger.Warnf("%s is not supported on %s, skipped", name, runtime.GOOS) })
+			return nil
+		}
+		return err
+	}
+}
+
+// setSockOpts applies sockOpts to the socket fd, it stops at the first error.
+func setSockOpts(fd int, sockOpts []socket.Option) error {
+	for _, sockOpt := range sockOpts {
+		if err := sockOpt.SetSockOpt(fd, sockOpt.Opt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// controlSocket calls the callbacks of Options.SocketOptions on the socket fd, it stops at the first error.
+func controlSocket(fd int, callbacks []func(fd int) error) error {
+	for _, f := range callbacks {
+		if err := f(fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seconds converts d to seconds for the socket options, it's rounded up to one second at least.
+func seconds(d time.Duration) int {
+	if secs := int(d / time.Second); secs > 0 {
+		return secs
+	}
+	return 1
+}
+
+// lingerSeconds converts d to the timeout of socket.SetLinger, the connections are reset on Close if d is
+// less than zero, and SO_LINGER is turned off to restore the system default if d is zero.
+func lingerSeconds(d time.Duration) int {
+	switch {
+	case d > 0:
+		return seconds(d)
+	case d < 0:
+		return 0
+	default:
+		return -1
+	}
+}
+
+func (c *conn) isTCP() bool {
+	_, ok := c.localAddr.(*net.TCPAddr)
+	return ok
+}
+
+func (c *conn) TCPInfo() (*TCPInfo, error) {
+	if !c.isTCP() {
+		return nil, errors.ErrUnsupportedOp
+	}
+	info, err := socket.GetTCPInfo(c.fd)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPInfo{
+		State:         info.State,
+		RTT:           time.Duration(info.Rtt) * time.Microsecond,
+		RTTVar:        time.Duration(info.Rttvar) * time.Microsecond,
+		MinRTT:        time.Duration(info.MinRtt) * time.Microsecond,
+		RTO:           time.Duration(info.Rto) * time.Microsecond,
+		Retransmits:   uint32(info.Retransmits),
+		TotalRetrans:  info.TotalRetrans,
+		Lost:          info.Lost,
+		Unacked:       info.Unacked,
+		SndCwnd:       info.SndCwnd,
+		SndSsthresh:   info.SndSsthresh,
+		SndMSS:        info.SndMss,
+		RcvMSS:        info.RcvMss,
+		NotSentBytes:  info.NotsentBytes,
+		BytesSent:     info.BytesSent,
+		BytesAcked:    info.BytesAcked,
+		BytesReceived: info.BytesReceived,
+		BytesRetrans:  info.BytesRetrans,
+		DeliveryRate:  info.DeliveryRate,
+		PacingRate:    info.PacingRate,
+	}, nil
+}
+
+func (c *conn) Control(f func(fd int) error) error {
+	return f(c.fd)
+}
+
+func (c *conn) SetKeepAlive(idle, interval time.Duration, count int) error {
+	if !c.isTCP() {
+		return errors.ErrUnsupportedOp
+	}
+	secs := 0 // rejected by socket.SetKeepAlive
+	if idle > 0 {
+		secs = seconds(idle)
+	}
+	if err := socket.SetKeepAlive(c.fd, secs); err != nil {
+		return err
+	}
+	if interval > 0 {
+		if err := socket.SetKeepAliveInterval(c.fd, seconds(interval)); err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		return socket.SetKeepAliveCount(c.fd, count)
+	}
+	return nil
+}
+
+func (c *conn) SetUserTimeout(timeout time.Duration) error {
+	if !c.isTCP() {
+		return errors.ErrUnsupportedOp
+	}
+	return socket.SetUserTimeout(c.fd, int(timeout/time.Millisecond))
+}
+
+func (c *conn) SetLinger(linger time.Duration) error {
+	if !c.isTCP() {
+		return errors.ErrUnsupportedOp
+	}
+	return socket.SetLinger(c.fd, lingerSeconds(linger))
+}
+
+func (c *conn) SetTOS(tos int) error {
+	if !c.isTCP() {
+		return errors.ErrUnsupportedOp
+	}
+	return socket.SetTOS(c.fd, tos)
+}
+
+func (c *conn) SetQuickAck(quickAck bool) error {
+	if !c.isTCP() {
+		return errors.ErrUnsupportedOp
+	}
+	var v int
+	if quickAck {
+		v = 1
+	}
+	return socket.SetQuickAck(c.fd, v)
+}
+
+func (c *conn) SetNotSentLowat(bytes int) error {
+	if !c.isTCP() {
+		return errors.ErrUnsupportedOp
+	}
+	return socket.SetNotSentLowat(c.fd, bytes)
+}
+
+func (c *conn) SetCongestion(name string) error {
+	if !c.isTCP() {
+		return errors.ErrUnsupportedOp
+	}
+	return socket.SetCongestion(c.fd, name)
+}
+
+func (c *conn) SetMark(mark int) error {
+	if !c.isTCP() {
+		return errors.ErrUnsupportedOp
+	}
+	return socket.SetMark(c.fd, mark)
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build freebsd || dragonfly || darwin
+// +build freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/v2/internal/netpoll"
+)
+
+// udpBatch is not supported on BSD, the datagrams are always read and sent one by one.
+type udpBatch struct{}
+
+func newUDPBatch(_ *Options) *udpBatch {
+	return nil
+}
+
+func (el *eventloop) readUDPBatch(_ int, _ netpoll.IOEvent) error {
+	return nil
+}
+
+func (el *eventloop) queueUDP(_ int, _ unix.Sockaddr, _ []byte, _ []byte) bool {
+	return false
+}
+
+func (el *eventloop) discardUDP(_ int) {}
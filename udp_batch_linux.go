@@ -0,0 +1,398 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package gnet
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/v2/internal/io"
+	"github.com/panjf2000/gnet/v2/internal/netpoll"
+	"github.com/panjf2000/gnet/v2/internal/socket"
+	bsPool "github.com/panjf2000/gnet/v2/pkg/pool/byteslice"
+)
+
+// The limits of the datagrams coalesced into one UDP GSO send, see UDP_MAX_SEGMENTS in linux/udp.h,
+// the total size is bounded by the maximum payload of an IPv6 packet without extension headers.
+const (
+	udpMaxSegments = 64
+	udpMaxGSOSize  = 0xffff - 40 - 8
+)
+
+// udpMaxStalled is the maximum number of datagrams kept for a socket whose send buffer is full,
+// the datagrams written to the socket beyond it are dropped until the socket becomes writable.
+const udpMaxStalled = 1024
+
+// udpBatch holds the buffers for reading datagrams with recvmmsg(2) and
+// the datagrams queued for sendmmsg(2) while a batch is being processed.
+type udpBatch struct {
+	reading bool                  // whether the event-loop is processing a batch of datagrams
+	gso     bool                  // whether to coalesce the queued datagrams with UDP GSO
+	msgs    []io.Mmsghdr          // message headers for recvmmsg
+	names   []unix.RawSockaddrAny // source addresses of the received datagrams
+	iovs    []unix.Iovec          // buffers of the received datagrams
+	bufs    [][]byte              // buffers of the received datagrams
+	oobs    [][]byte              // ancillary data of the received datagrams
+
+	pending  []pendingDatagram     // datagrams queued during the batch
+	outMsgs  []io.Mmsghdr          // message headers for sendmmsg
+	outNames []unix.RawSockaddrAny // destination addresses of the queued datagrams
+	outIovs  []unix.Iovec          // buffers of the queued datagrams, one for each datagram
+	outOOBs  [][]byte              // UDP_SEGMENT and packet information control messages of the messages
+	outFirst []int                 // index of the first datagram packed into each message
+	outSegs  []int                 // number of datagrams packed into each message
+
+	stalled   map[int][]pendingDatagram // datagrams left unsent due to a full send buffer: fd -> datagrams
+	dropped   uint64                    // number of datagrams dropped due to full send buffers
+	logged    uint64                    // number of dropped datagrams that have been logged
+	lastLogAt time.Time                 // last time the dropped datagrams were logged
+}
+
+type pendingDatagram struct {
+	fd  int           // socket to send the datagram on
+	sa  unix.Sockaddr // destination address, nil for connected sockets
+	src []byte        // copy of the source IP address, nil if it's chosen by the kernel
+	buf []byte        // copy of the datagram
+}
+
+// newUDPBatch returns nil if batched UDP I/O is disabled.
+func newUDPBatch(opts *Options) *udpBatch {
+	size, bufCap := opts.UDPBatchSize, opts.ReadBufferCap
+	if size < 2 {
+		return nil
+	}
+	b := &udpBatch{
+		gso:      opts.UDPGSO,
+		msgs:     make([]io.Mmsghdr, size),
+		names:    make([]unix.RawSockaddrAny, size),
+		iovs:     make([]unix.Iovec, size),
+		bufs:     make([][]byte, size),
+		oobs:     make([][]byte, size),
+		pending:  make([]pendingDatagram, 0, size),
+		outMsgs:  make([]io.Mmsghdr, size),
+		outNames: make([]unix.RawSockaddrAny, size),
+		outIovs:  make([]unix.Iovec, size),
+		outOOBs:  make([][]byte, size),
+		outFirst: make([]int, size),
+		outSegs:  make([]int, size),
+		stalled:  make(map[int][]pendingDatagram),
+	}
+	buf := make([]byte, size*bufCap)
+	oobSpace := socket.UDPGROCmsgSpace + socket.PktInfoCmsgSpace
+	outOOBSpace := socket.UDPSegmentCmsgSpace + socket.PktInfoCmsgSpace
+	oob := make([]byte, size*oobSpace)
+	outOOB := make([]byte, size*outOOBSpace)
+	for i := 0; i < size; i++ {
+		b.bufs[i] = buf[i*bufCap : (i+1)*bufCap : (i+1)*bufCap]
+		b.oobs[i] = oob[i*oobSpace : (i+1)*oobSpace]
+		b.outOOBs[i] = outOOB[i*outOOBSpace : (i+1)*outOOBSpace]
+		b.iovs[i].Base = &b.bufs[i][0]
+		b.iovs[i].SetLen(bufCap)
+		b.msgs[i].Hdr.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+		b.msgs[i].Hdr.Iov = &b.iovs[i]
+		b.msgs[i].Hdr.SetIovlen(1)
+	}
+	return b
+}
+
+// recv reads up to len(b.msgs) datagrams from fd.
+func (b *udpBatch) recv(fd int) (int, error) {
+	for i := range b.msgs {
+		b.msgs[i].Hdr.Namelen = unix.SizeofSockaddrAny
+		b.msgs[i].Hdr.Control = &b.oobs[i][0]
+		b.msgs[i].Hdr.SetControllen(len(b.oobs[i]))
+		b.msgs[i].Hdr.Flags = 0
+		b.msgs[i].Len = 0
+	}
+	return io.Recvmmsg(fd, b.msgs, 0)
+}
+
+// readUDPBatch reads a batch of datagrams with recvmmsg(2) and invokes OnTraffic for each of them,
+// the datagrams written back to the peers during the batch are sent with sendmmsg(2) afterwards.
+// The datagrams left unsent due to a full send buffer are sent once the socket becomes writable.
+func (el *eventloop) readUDPBatch(fd int, ev netpoll.IOEvent) error {
+	b := el.udpBatch
+	if _, ok := b.stalled[fd]; ok && ev&netpoll.OutEvents != 0 {
+		el.flushStalledUDP(fd)
+	}
+	if ev&netpoll.InEvents == 0 {
+		return nil
+	}
+	n, err := b.recv(fd)
+	if err != nil {
+		if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+			return nil
+		}
+		return fmt.Errorf("failed to read UDP packets from fd=%d in event-loop(%d), %v",
+			fd, el.idx, os.NewSyscallError("recvmmsg", err))
+	}
+
+	b.reading = true
+	defer func() {
+		b.reading = false
+		el.flushUDP()
+	}()
+	for i := 0; i < n; i++ {
+		// The buffer may consist of multiple datagrams from the same peer coalesced by UDP GRO.
+		oob := b.oobs[i][:b.msgs[i].Hdr.Controllen]
+		segSize := socket.UDPGROSegmentSize(oob)
+		err = el.handleDatagram(fd, socket.SockaddrFromRaw(&b.names[i], b.msgs[i].Hdr.Namelen), socket.ParsePktInfo(oob),
+			b.bufs[i][:b.msgs[i].Len], segSize)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueUDP queues a copy of buf for sendmmsg(2) if the event-loop is processing a batch of datagrams,
+// it returns false if buf ought to be sent right away.
+// The datagrams written to a socket whose send buffer is full are queued as well to keep them in order.
+func (el *eventloop) queueUDP(fd int, sa unix.Sockaddr, src []byte, buf []byte) bool {
+	b := el.udpBatch
+	if b == nil {
+		return false
+	}
+	_, stalled := b.stalled[fd]
+	if !b.reading && !stalled {
+		return false
+	}
+	d := pendingDatagram{fd: fd, sa: sa, buf: bsPool.Get(len(buf))}
+	copy(d.buf, buf)
+	// The source address is released along with the connection before the datagram is sent.
+	if src != nil {
+		d.src = bsPool.Get(len(src))
+		copy(d.src, src)
+	}
+	if !b.reading {
+		ds := []pendingDatagram{d}
+		el.stallUDP(fd, ds)
+		releaseDatagrams(ds)
+		return true
+	}
+	b.pending = append(b.pending, d)
+	if len(b.pending) == cap(b.pending) {
+		el.flushUDP()
+	}
+	return true
+}
+
+// flushUDP sends the queued datagrams, the consecutive datagrams on the same socket are sent with one sendmmsg(2).
+func (el *eventloop) flushUDP() {
+	b := el.udpBatch
+	for i := 0; i < len(b.pending); {
+		fd := b.pending[i].fd
+		j := i + 1
+		for j < len(b.pending) && b.pending[j].fd == fd {
+			j++
+		}
+		if _, ok := b.stalled[fd]; ok {
+			el.stallUDP(fd, b.pending[i:j])
+		} else if sent := el.sendUDPBatch(fd, b.pending[i:j]); i+sent < j {
+			el.stallUDP(fd, b.pending[i+sent:j])
+		}
+		i = j
+	}
+	releaseDatagrams(b.pending)
+	b.pending = b.pending[:0]
+}
+
+// releaseDatagrams puts the buffers of ds back to the pool, except for the ones that have been taken over.
+func releaseDatagrams(ds []pendingDatagram) {
+	for i := range ds {
+		if ds[i].buf != nil {
+			bsPool.Put(ds[i].buf)
+		}
+		if ds[i].src != nil {
+			bsPool.Put(ds[i].src)
+		}
+		ds[i] = pendingDatagram{}
+	}
+}
+
+// stallUDP takes over the datagrams ds that can't be sent on fd due to a full send buffer and starts watching
+// the writable events of fd, the datagrams beyond udpMaxStalled are dropped and left to the caller to release.
+func (el *eventloop) stallUDP(fd int, ds []pendingDatagram) {
+	b := el.udpBatch
+	q, ok := b.stalled[fd]
+	if !ok {
+		pa := el.udpPollAttachment(fd)
+		if pa == nil {
+			el.dropUDP(fd, len(ds))
+			return
+		}
+		if err := el.poller.ModReadWrite(pa); err != nil {
+			el.getLogger().Errorf("failed to watch the writable events of fd=%d in event-loop(%d), %v", fd, el.idx, err)
+			el.dropUDP(fd, len(ds))
+			return
+		}
+	}
+	for i := range ds {
+		if len(q) >= udpMaxStalled {
+			el.dropUDP(fd, len(ds)-i)
+			break
+		}
+		q = append(q, ds[i])
+		ds[i].buf, ds[i].src = nil, nil
+	}
+	b.stalled[fd] = q
+}
+
+// flushStalledUDP sends the datagrams left unsent on fd once it becomes writable, and stops watching
+// the writable events of fd after all of them have been sent.
+func (el *eventloop) flushStalledUDP(fd int) {
+	b := el.udpBatch
+	q := b.stalled[fd]
+	sent := el.sendUDPBatch(fd, q)
+	releaseDatagrams(q[:sent])
+	if sent < len(q) {
+		b.stalled[fd] = append(q[:0], q[sent:]...)
+		return
+	}
+	delete(b.stalled, fd)
+	if pa := el.udpPollAttachment(fd); pa != nil {
+		if err := el.poller.ModRead(pa); err != nil {
+			el.getLogger().Errorf("failed to stop watching the writable events of fd=%d in event-loop(%d), %v",
+				fd, el.idx, err)
+		}
+	}
+}
+
+// discardUDP drops the datagrams left unsent on fd which is about to be closed.
+func (el *eventloop) discardUDP(fd int) {
+	if el.udpBatch == nil {
+		return
+	}
+	if q, ok := el.udpBatch.stalled[fd]; ok {
+		releaseDatagrams(q)
+		delete(el.udpBatch.stalled, fd)
+	}
+}
+
+// dropUDP counts the n datagrams dropped on fd, which are logged at most once per second.
+func (el *eventloop) dropUDP(fd, n int) {
+	b := el.udpBatch
+	b.dropped += uint64(n)
+	if now := time.Now(); now.Sub(b.lastLogAt) >= time.Second {
+		el.getLogger().Warnf("dropped %d UDP packets in event-loop(%d) due to full send buffers, "+
+			"the latest on fd=%d, %d in total", b.dropped-b.logged, el.idx, fd, b.dropped)
+		b.logged, b.lastLogAt = b.dropped, now
+	}
+}
+
+// pack packs the datagrams into the message headers for sendmmsg(2), the consecutive datagrams of the same size
+// from the same source to the same peer are packed into one message with UDP GSO if gso is true, it returns the number of messages
+// and the number of datagrams packed.
+func (b *udpBatch) pack(ds []pendingDatagram, gso bool) (nmsg, packed int) {
+	for packed < len(ds) && nmsg < len(b.outMsgs) {
+		d := ds[packed]
+		segs, total := 1, len(d.buf)
+		for gso && len(d.buf) > 0 && packed+segs < len(ds) && segs < udpMaxSegments {
+			next := ds[packed+segs]
+			if len(next.buf) == 0 || len(next.buf) > len(d.buf) || total+len(next.buf) > udpMaxGSOSize ||
+				!socket.SockaddrEqual(next.sa, d.sa) || !bytes.Equal(next.src, d.src) {
+				break
+			}
+			total += len(next.buf)
+			segs++
+			// Only the last segment can be shorter than the segment size.
+			if len(next.buf) < len(d.buf) {
+				break
+			}
+		}
+
+		hdr := &b.outMsgs[nmsg].Hdr
+		*hdr = unix.Msghdr{}
+		if d.sa != nil {
+			namelen, err := socket.SockaddrToRaw(d.sa, &b.outNames[nmsg])
+			if err != nil {
+				packed += segs
+				continue
+			}
+			hdr.Name = (*byte)(unsafe.Pointer(&b.outNames[nmsg]))
+			hdr.Namelen = namelen
+		}
+		for i := packed; i < packed+segs; i++ {
+			b.outIovs[i] = unix.Iovec{}
+			if len(ds[i].buf) > 0 {
+				b.outIovs[i].Base = &ds[i].buf[0]
+			}
+			b.outIovs[i].SetLen(len(ds[i].buf))
+		}
+		hdr.Iov = &b.outIovs[packed]
+		hdr.SetIovlen(segs)
+		var oobn int
+		if segs > 1 {
+			oobn = socket.PutUDPSegment(b.outOOBs[nmsg], uint16(len(d.buf)))
+		}
+		if d.src != nil {
+			oobn += socket.PutPktInfo(b.outOOBs[nmsg][oobn:], d.src)
+		}
+		if oobn > 0 {
+			hdr.Control = &b.outOOBs[nmsg][0]
+			hdr.SetControllen(oobn)
+		}
+		b.outFirst[nmsg], b.outSegs[nmsg] = packed, segs
+		nmsg++
+		packed += segs
+	}
+	return
+}
+
+// sendUDPBatch sends the datagrams ds on fd, it returns the number of the datagrams that have been done with,
+// which is less than len(ds) if the send buffer of fd is full.
+func (el *eventloop) sendUDPBatch(fd int, ds []pendingDatagram) (done int) {
+	b := el.udpBatch
+	gso := b.gso
+	for done < len(ds) {
+		nmsg, packed := b.pack(ds[done:], gso)
+		msgs := b.outMsgs[:nmsg]
+	send:
+		for i := 0; i < len(msgs); {
+			n, err := io.Sendmmsg(fd, msgs[i:], 0)
+			switch {
+			case err == nil:
+				i += n
+			case err == unix.EAGAIN || err == unix.EWOULDBLOCK:
+				return done + b.outFirst[i]
+			case b.outSegs[i] > 1:
+				// The kernel rejects UDP GSO, e.g. the segment size exceeds the MTU or the device doesn't
+				// support checksum offload, send the rest of datagrams one by one instead.
+				if err == unix.EIO || err == unix.ENOPROTOOPT || err == unix.EOPNOTSUPP {
+					el.getLogger().Warnf("UDP GSO is disabled in event-loop(%d) due to %v", el.idx, err)
+					b.gso = false
+				}
+				gso = false
+				packed = b.outFirst[i]
+				break send
+			default:
+				// Skip the datagram that failed and move on.
+				el.getLogger().Debugf("failed to send UDP packet on fd=%d in event-loop(%d), %v",
+					fd, el.idx, os.NewSyscallError("sendmmsg", err))
+				i++
+			}
+		}
+		done += packed
+	}
+	return
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/v2/internal/netpoll"
+	"github.com/panjf2000/gnet/v2/pkg/logging"
+)
+
+func TestUDPBatchStalled(t *testing.T) {
+	// The datagrams sent on a unix datagram socket are charged to its send buffer until they're received,
+	// so the sends fail with EAGAIN once the small send buffer is full, just like a UDP socket.
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK, 0)
+	require.NoError(t, err)
+	defer unix.Close(fds[1])
+	require.NoError(t, unix.SetsockoptInt(fds[0], unix.SOL_SOCKET, unix.SO_SNDBUF, 1024))
+	p, err := netpoll.OpenPoller()
+	require.NoError(t, err)
+	defer p.Close()
+
+	opts := &Options{Logger: logging.GetDefaultLogger(), UDPBatchSize: 8, ReadBufferCap: 64}
+	el := &eventloop{
+		engine:     &engine{opts: opts},
+		poller:     p,
+		udpBatch:   newUDPBatch(opts),
+		udpSockets: make(map[int]*conn),
+	}
+	c := &conn{fd: fds[0], pollAttachment: &netpoll.PollAttachment{FD: fds[0], Callback: el.readUDP}}
+	require.NoError(t, p.AddRead(c.pollAttachment))
+	el.udpSockets[fds[0]] = c
+
+	const count = 100
+	b := el.udpBatch
+	b.reading = true
+	for i := 0; i < count; i++ {
+		require.True(t, el.queueUDP(fds[0], nil, nil, []byte(strconv.Itoa(i))))
+	}
+	b.reading = false
+	el.flushUDP()
+	require.NotEmpty(t, b.stalled[fds[0]], "datagrams should be kept for the full socket")
+
+	// The datagrams written after the socket is stalled are queued behind the stalled ones.
+	require.True(t, el.queueUDP(fds[0], nil, nil, []byte(strconv.Itoa(count))))
+
+	buf := make([]byte, 64)
+	for i := 0; i <= count; i++ {
+		n, err := unix.Read(fds[1], buf)
+		if err == unix.EAGAIN {
+			// Send the rest once the socket becomes writable.
+			require.NoError(t, el.readUDP(fds[0], unix.EPOLLOUT))
+			n, err = unix.Read(fds[1], buf)
+		}
+		require.NoError(t, err)
+		assert.EqualValues(t, strconv.Itoa(i), buf[:n])
+	}
+	assert.Empty(t, b.stalled)
+	assert.Zero(t, b.dropped)
+	unix.Close(fds[0])
+	el.discardUDP(fds[0])
+}
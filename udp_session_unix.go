@@ -0,0 +1,136 @@
+// Copyright (c) 2022 Andy Pan
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || darwin
+// +build linux freebsd dragonfly darwin
+
+package gnet
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	gerrors "github.com/panjf2000/gnet/v2/pkg/errors"
+)
+
+// udpSessionKey identifies a session by the datagram listener and the address of the peer.
+type udpSessionKey struct {
+	fd   int      // file descriptor of the datagram listener
+	port int      // port of the peer
+	zone uint32   // IPv6 zone of the peer
+	addr [16]byte // IP address of the peer, IPv4 addresses are mapped into IPv6
+	name string   // name of the unixgram peer
+}
+
+// newUDPSessionKey returns false if the peer can't be identified, e.g. an unnamed unixgram socket,
+// whose datagrams are handled by one-off connections.
+func newUDPSessionKey(fd int, sa unix.Sockaddr) (key udpSessionKey, ok bool) {
+	key.fd = fd
+	switch sa := sa.(type) {
+	case *unix.SockaddrUnix:
+		if sa.Name == "" || sa.Name == "@" {
+			return
+		}
+		key.name = sa.Name
+	case *unix.SockaddrInet4:
+		key.port = sa.Port
+		key.addr[10], key.addr[11] = 0xff, 0xff
+		copy(key.addr[12:], sa.Addr[:])
+	case *unix.SockaddrInet6:
+		key.port, key.zone = sa.Port, sa.ZoneId
+		key.addr = sa.Addr
+	default:
+		return
+	}
+	return key, true
+}
+
+// udpSession returns the session of the peer sa on the UDP listener ln, a new session is opened for the first
+// datagram from the peer, it returns nil if the new session is closed in OnOpen. The local address of the
+// session follows the local IP address of the latest datagram, see eventloop.handleDatagram.
+func (el *eventloop) udpSession(ln *listener, key udpSessionKey, sa unix.Sockaddr, local []byte) (*conn, error) {
+	if c, ok := el.udpSessions[key]; ok {
+		c.activeAt = time.Now()
+		if addr, ok := c.localAddr.(*net.UDPAddr); ok && local != nil && !addr.IP.Equal(local) {
+			c.localAddr = ln.udpLocalAddr(local)
+		}
+		return c, nil
+	}
+
+	c := newUDPConn(ln.fd, el, ln.udpLocalAddr(local), sa, false)
+	c.ln = ln
+	c.opened = true
+	c.session = true
+	c.activeAt = time.Now()
+	el.udpSessions[key] = c
+	el.addConn(1)
+	el.scheduleUDPSessionExpiry()
+
+	out, action := el.eventHandler.OnOpen(c)
+	if out != nil {
+		if err := c.writeTo(out); err != nil {
+			el.getLogger().Debugf("failed to send UDP packet to %v in event-loop(%d), %v", c.remoteAddr, el.idx, err)
+		}
+	}
+	switch action {
+	case Close:
+		return nil, el.closeConn(c, nil)
+	case Shutdown:
+		return nil, gerrors.ErrEngineShutdown
+	}
+	return c, nil
+}
+
+// closeUDPSession closes the UDP session c, the UDP listener it belongs to is left untouched.
+func (el *eventloop) closeUDPSession(c *conn) {
+	if key, ok := newUDPSessionKey(c.fd, c.peer); ok {
+		delete(el.udpSessions, key)
+	}
+	c.opened = false
+	el.addConn(-1)
+}
+
+// scheduleUDPSessionExpiry makes sure that the idle UDP sessions will be checked in a while.
+func (el *eventloop) scheduleUDPSessionExpiry() {
+	if len(el.udpSessions) != 1 {
+		return
+	}
+	interval := el.engine.opts.UDPSessionTimeout / 2
+	if el.udpSessionTimer == nil {
+		el.udpSessionTimer = time.AfterFunc(interval, func() {
+			_ = el.poller.Trigger(el.expireUDPSessions, nil)
+		})
+		return
+	}
+	el.udpSessionTimer.Reset(interval)
+}
+
+// expireUDPSessions closes the UDP sessions that have been idle for Options.UDPSessionTimeout.
+func (el *eventloop) expireUDPSessions(_ interface{}) error {
+	now := time.Now()
+	for _, c := range el.udpSessions {
+		if now.Sub(c.activeAt) < el.engine.opts.UDPSessionTimeout {
+			continue
+		}
+		if err := el.closeConn(c, gerrors.ErrUDPSessionExpired); err != nil {
+			return err
+		}
+	}
+	if len(el.udpSessions) > 0 {
+		el.udpSessionTimer.Reset(el.engine.opts.UDPSessionTimeout / 2)
+	}
+	return nil
+}